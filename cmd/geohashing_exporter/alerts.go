@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the optional in-process alert engine, evaluating distance
+// rules for the configured locations and notifying a webhook, without the need
+// for Prometheus and an Alertmanager.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookAlert is a single alert within a webhookPayload.
+type webhookAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorUrl string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// webhookPayload is sent to the webhook, compatible with the Alertmanager's
+// webhook_config payload.
+//
+// https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type webhookPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalUrl       string            `json:"externalURL"`
+	Alerts            []webhookAlert    `json:"alerts"`
+}
+
+// fingerprint of a label set, identifying an alert for deduplication.
+func fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s\xff%s\xff", key, labels[key])
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// alertEngine evaluates the alert rules for the configured locations.
+//
+// Each hash within a rule's distance fires an alert once. As a hash never
+// changes, its alert stays active until the hash's day has passed in the
+// location's time zone and will be resolved then.
+type alertEngine struct {
+	conf   *config
+	client *http.Client
+	// now is the current time, only to be altered for testing.
+	now func() time.Time
+
+	// active alerts by their fingerprint, already sent to the webhook.
+	active map[string]webhookAlert
+	// trigger an evaluation besides the interval.
+	trigger chan struct{}
+	// evalLock serializes evaluations.
+	evalLock sync.Mutex
+}
+
+// newAlertEngine for the config, which must have alerts.
+func newAlertEngine(conf *config) *alertEngine {
+	return &alertEngine{
+		conf:    conf,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+		active:  make(map[string]webhookAlert),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// notify the engine about a new DJIA, implementing djiaListener, to evaluate
+// the rules for the new hashes without waiting for the next interval.
+func (engine *alertEngine) notify(_ time.Time, _ float64) {
+	select {
+	case engine.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// ruleAlerts evaluates a single rule for a named location.
+func (engine *alertEngine) ruleAlerts(rule alertRuleConfig, name string, t target, ctx context.Context) (alerts []webhookAlert) {
+	var neighbours []neighbour
+	if !rule.Globalhash {
+		center, _ := graticuleFromPoint(t.lat, t.lon)
+		neighbours = neighbourhood(center, t.radius)
+	}
+
+	results := computeHashes(neighbours, rule.Globalhash, engine.now().In(t.tz), ctx)
+	for _, result := range results {
+		if result.err != nil && len(result.hashes) == 0 {
+			log.Printf("Evaluating alert rule %q for %s at %s failed: %v", rule.Name, name, result.name(), result.err)
+			continue
+		}
+
+		for _, hash := range result.hashes {
+			dist := distance(t.lat, t.lon, hash.Lat, hash.Lon)
+			if dist > rule.MaxDistanceKm*1000 {
+				continue
+			}
+
+			graticule := ""
+			if result.neighbour != nil {
+				graticule = result.neighbour.graticule.String()
+			}
+			expedition := expeditionName(hash.Date, result.neighbour)
+
+			year, month, day := hash.Date.Date()
+			endsAt := time.Date(year, month, day, 0, 0, 0, 0, t.tz).AddDate(0, 0, 1)
+
+			labels := map[string]string{
+				"alertname": rule.Name,
+				"target":    name,
+				"location":  result.name(),
+				"graticule": graticule,
+				"date":      hash.Date.Format("2006-01-02"),
+			}
+			alerts = append(alerts, webhookAlert{
+				Status: "firing",
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary":         fmt.Sprintf("%s is %.1f km away from %s", expedition, dist/1000, name),
+					"description":     hashDescription(hash, result.neighbour),
+					"lat":             fmt.Sprintf("%f", hash.Lat),
+					"lon":             fmt.Sprintf("%f", hash.Lon),
+					"distance_meters": fmt.Sprintf("%.0f", dist),
+				},
+				EndsAt:       endsAt,
+				GeneratorUrl: "https://geohashing.site/geohashing/" + strings.ReplaceAll(expedition, " ", "_"),
+				Fingerprint:  fingerprint(labels),
+			})
+		}
+	}
+	return
+}
+
+// send a payload for the alerts of a rule with the status to the webhook.
+func (engine *alertEngine) send(ruleName, status string, alerts []webhookAlert, ctx context.Context) error {
+	payload := webhookPayload{
+		Version:           "4",
+		GroupKey:          fmt.Sprintf("{}:{alertname=%q}", ruleName),
+		Status:            status,
+		Receiver:          "geohashing_exporter",
+		GroupLabels:       map[string]string{"alertname": ruleName},
+		CommonLabels:      map[string]string{"alertname": ruleName},
+		CommonAnnotations: map[string]string{},
+		Alerts:            alerts,
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, engine.conf.Alerts.WebhookUrl, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := engine.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// evaluate all rules once, sending new firing alerts and resolving those whose
+// day has passed. Alerts failed to be sent are retried on the next evaluation.
+func (engine *alertEngine) evaluate(ctx context.Context) {
+	engine.evalLock.Lock()
+	defer engine.evalLock.Unlock()
+
+	now := engine.now()
+
+	for _, rule := range engine.conf.Alerts.Rules {
+		names := rule.Locations
+		if len(names) == 0 {
+			for name := range engine.conf.targets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		var firing, resolved []webhookAlert
+		for _, name := range names {
+			for _, alert := range engine.ruleAlerts(rule, name, engine.conf.targets[name], ctx) {
+				if _, ok := engine.active[alert.Fingerprint]; ok || !now.Before(alert.EndsAt) {
+					continue
+				}
+				alert.StartsAt = now
+				firing = append(firing, alert)
+			}
+		}
+
+		for _, alert := range engine.active {
+			if alert.Labels["alertname"] == rule.Name && !now.Before(alert.EndsAt) {
+				alert.Status = "resolved"
+				resolved = append(resolved, alert)
+			}
+		}
+		sort.Slice(resolved, func(i, j int) bool { return resolved[i].Fingerprint < resolved[j].Fingerprint })
+
+		updates := []struct {
+			status string
+			alerts []webhookAlert
+		}{
+			{"firing", firing},
+			{"resolved", resolved},
+		}
+		for _, update := range updates {
+			if len(update.alerts) == 0 {
+				continue
+			}
+
+			err := engine.send(rule.Name, update.status, update.alerts, ctx)
+			if err != nil {
+				log.Printf("Sending %d %s alerts of %q failed: %v", len(update.alerts), update.status, rule.Name, err)
+				continue
+			}
+
+			for _, alert := range update.alerts {
+				if update.status == "firing" {
+					engine.active[alert.Fingerprint] = alert
+				} else {
+					delete(engine.active, alert.Fingerprint)
+				}
+			}
+		}
+	}
+}
+
+// run the engine until the context is done, evaluating the rules each
+// interval or when triggered by notify.
+func (engine *alertEngine) run(ctx context.Context) {
+	ticker := time.NewTicker(engine.conf.Alerts.Interval)
+	defer ticker.Stop()
+
+	for {
+		evalCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		engine.evaluate(evalCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-engine.trigger:
+		}
+	}
+}
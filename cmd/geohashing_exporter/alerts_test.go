@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	a := fingerprint(map[string]string{"alertname": "foo", "date": "2022-07-16"})
+	b := fingerprint(map[string]string{"date": "2022-07-16", "alertname": "foo"})
+	c := fingerprint(map[string]string{"alertname": "foo", "date": "2022-07-17"})
+
+	if a != b {
+		t.Fatalf("fingerprint depends on the order: %q != %q", a, b)
+	}
+	if a == c {
+		t.Fatalf("fingerprints of different labels are equal: %q", a)
+	}
+}
+
+// webhookRecorder is a webhook, recording all received payloads.
+type webhookRecorder struct {
+	payloads []webhookPayload
+	// fail all requests with an Internal Server Error.
+	fail bool
+	lock sync.Mutex
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	if rec.fail {
+		http.Error(w, "nope", http.StatusInternalServerError)
+		return
+	}
+
+	var payload webhookPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec.payloads = append(rec.payloads, payload)
+}
+
+// pop all recorded payloads.
+func (rec *webhookRecorder) pop() (payloads []webhookPayload) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	payloads, rec.payloads = rec.payloads, nil
+	return
+}
+
+func TestAlertEngine(t *testing.T) {
+	setupTestProvider(t)
+
+	webhook := &webhookRecorder{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	conf := testConfig()
+	conf.Alerts = &alertsConfig{
+		WebhookUrl: server.URL,
+		Rules: []alertRuleConfig{
+			{Name: "GeohashNearby", MaxDistanceKm: 52},
+			{Name: "GlobalhashNearby", Locations: []string{"home"}, MaxDistanceKm: 5000, Globalhash: true},
+		},
+	}
+	if err := conf.Alerts.validate(conf.targets); err != nil {
+		t.Fatal(err)
+	}
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+
+	engine := newAlertEngine(conf)
+	engine.now = func() time.Time { return now }
+
+	// A failing webhook will be retried on the next evaluation.
+	webhook.fail = true
+	engine.evaluate(context.Background())
+	if len(engine.active) != 0 {
+		t.Fatalf("expected no active alerts instead of %d", len(engine.active))
+	}
+	webhook.fail = false
+
+	engine.evaluate(context.Background())
+	payloads := webhook.pop()
+	if len(payloads) != 2 {
+		t.Fatalf("expected two payloads instead of %d: %#v", len(payloads), payloads)
+	}
+
+	geohashes := payloads[0]
+	if geohashes.Status != "firing" || geohashes.Version != "4" || geohashes.GroupLabels["alertname"] != "GeohashNearby" {
+		t.Fatalf("unexpected payload %#v", geohashes)
+	}
+	centerDates := make(map[string]bool)
+	for _, alert := range geohashes.Alerts {
+		dist, err := strconv.ParseFloat(alert.Annotations["distance_meters"], 64)
+		if err != nil {
+			t.Fatal(err)
+		} else if dist > 52000 {
+			t.Fatalf("alert is %v m away: %#v", dist, alert)
+		}
+
+		if alert.Labels["target"] != "home" || alert.Status != "firing" || !alert.StartsAt.Equal(now) {
+			t.Fatalf("unexpected alert %#v", alert)
+		}
+		if alert.Labels["location"] == "center" {
+			centerDates[alert.Labels["date"]] = true
+		}
+	}
+	// The center's hash on 2022-07-16 is 54.1 km away.
+	if len(centerDates) != 2 || !centerDates["2022-07-17"] || !centerDates["2022-07-18"] {
+		t.Fatalf("unexpected center alerts %v", centerDates)
+	}
+
+	globalhashes := payloads[1]
+	if len(globalhashes.Alerts) != 1 {
+		t.Fatalf("expected one globalhash alert instead of %d", len(globalhashes.Alerts))
+	}
+	if alert := globalhashes.Alerts[0]; alert.Labels["location"] != "global" || alert.Labels["date"] != "2022-07-16" ||
+		alert.GeneratorUrl != "https://geohashing.site/geohashing/2022-07-16_global" {
+		t.Fatalf("unexpected globalhash alert %#v", alert)
+	}
+
+	// Alerts are deduplicated.
+	engine.evaluate(context.Background())
+	if payloads := webhook.pop(); len(payloads) != 0 {
+		t.Fatalf("expected no payloads instead of %#v", payloads)
+	}
+
+	// On the next day, the previous day's alerts are resolved, being only the
+	// globalhash. The other alerts stay active.
+	active := len(engine.active)
+	now = now.AddDate(0, 0, 1)
+	engine.evaluate(context.Background())
+	payloads = webhook.pop()
+	if len(payloads) != 1 || payloads[0].Status != "resolved" || len(payloads[0].Alerts) != 1 {
+		t.Fatalf("unexpected payloads %#v", payloads)
+	}
+	if alert := payloads[0].Alerts[0]; alert.Status != "resolved" || alert.Labels["location"] != "global" || alert.Labels["date"] != "2022-07-16" {
+		t.Fatalf("unexpected resolved alert %#v", alert)
+	}
+	if len(engine.active) != active-1 {
+		t.Fatalf("expected %d active alerts instead of %d", active-1, len(engine.active))
+	}
+}
@@ -0,0 +1,352 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the JSON REST API, serving the same data as the metrics
+// for other clients, e.g., chat bots or web pages.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// apiHash is the JSON representation of a geohash.
+type apiHash struct {
+	Date      string  `json:"date"`
+	DayOffset int     `json:"day_offset"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+
+	Djia     float64 `json:"djia"`
+	DjiaDate string  `json:"djia_date"`
+	W30      bool    `json:"w30"`
+
+	// ValidFrom and ValidUntil span the geohash's day in the requested time zone.
+	ValidFrom  time.Time `json:"valid_from"`
+	ValidUntil time.Time `json:"valid_until"`
+
+	// DistanceMeters to the home position, if given.
+	DistanceMeters *float64 `json:"distance_meters,omitempty"`
+}
+
+// apiError is the JSON representation of an error.
+type apiError struct {
+	Message string `json:"message"`
+	// Reason is either one of errorReason's values or "bad_request".
+	Reason string `json:"reason"`
+}
+
+// apiLocation is the JSON representation of a location's next geohashes.
+type apiLocation struct {
+	// Location is either "global" or a name created by neighbourName.
+	Location  string `json:"location"`
+	LatOffset *int   `json:"lat_offset,omitempty"`
+	LonOffset *int   `json:"lon_offset,omitempty"`
+	Graticule string `json:"graticule,omitempty"`
+
+	Hashes []apiHash `json:"hashes"`
+
+	// Stale is true if the hashes are from a previous calculation, see
+	// hashResult. Then, the Error is also set.
+	Stale bool      `json:"stale"`
+	Error *apiError `json:"error,omitempty"`
+}
+
+// apiParams are the parsed GET parameters of an API request.
+type apiParams struct {
+	target target
+	// date to calculate the geohashes for; defaults to now.
+	date time.Time
+	// home position to calculate distances, if hasHome is set.
+	homeLat, homeLon float64
+	hasHome          bool
+}
+
+// apiServer serves the JSON REST API.
+type apiServer struct {
+	conf *config
+	// events enables the event stream, requiring the prefetcher.
+	events *hashEvents
+}
+
+// parseDay in the format YYYY-MM-DD within the time zone.
+//
+// If the day's NYSE opening has already passed at now, the date is set to noon
+// in New York. Thus, the geohashes west of 30W are also available for past
+// days, independent of the time zone. Otherwise, the date is set to noon in the
+// time zone and geohashes west of 30W are reported as not yet available.
+func parseDay(value string, tz *time.Location, now time.Time) (date time.Time, err error) {
+	date, err = time.ParseInLocation("2006-01-02", value, tz)
+	if err != nil {
+		err = fmt.Errorf("%q is not formatted as YYYY-MM-DD", value)
+		return
+	}
+
+	year, month, day := date.Date()
+	if opening := time.Date(year, month, day, 9, 30, 0, 0, geohash.NyseTz()); !opening.After(now) {
+		date = time.Date(year, month, day, 12, 0, 0, 0, geohash.NyseTz())
+		return
+	}
+
+	date = date.Add(12 * time.Hour)
+	return
+}
+
+// parseParams of an API request.
+//
+// The position is either given by a named location from the config in the
+// `target` parameter or as `lat`, `lon`, `tz`, and `radius`, as for the
+// metricsHandler. If requirePosition is false, only the optional `tz` will be
+// used, defaulting to UTC.
+//
+// Optionally, a `date` in the format YYYY-MM-DD and a `home` position, e.g.,
+// "50.810222,8.767017", might be given. For a target, its position is the
+// default home.
+func (api *apiServer) parseParams(r *http.Request, requirePosition bool) (params apiParams, status int, err error) {
+	status = http.StatusBadRequest
+	query := r.URL.Query()
+
+	if name := query.Get("target"); name != "" {
+		t, ok := api.conf.targets[name]
+		if !ok {
+			status, err = http.StatusNotFound, fmt.Errorf("unknown target %q", name)
+			return
+		}
+
+		params.target = t
+		params.homeLat, params.homeLon, params.hasHome = t.lat, t.lon, true
+	} else if requirePosition {
+		params.target, err = metricsHandlerParseParams(r)
+		if err != nil {
+			return
+		}
+	} else {
+		params.target.tz = time.UTC
+		if tzName := query.Get("tz"); tzName != "" {
+			params.target.tz, err = time.LoadLocation(tzName)
+			if err != nil {
+				err = fmt.Errorf("cannot load `tz` GET parameter as a time zone: %v", err)
+				return
+			}
+		}
+	}
+
+	now := time.Now()
+	params.date = now.In(params.target.tz)
+	if dateParam := query.Get("date"); dateParam != "" {
+		params.date, err = parseDay(dateParam, params.target.tz, now)
+		if err != nil {
+			err = fmt.Errorf("cannot parse `date` GET parameter: %v", err)
+			return
+		}
+	}
+
+	if homeParam := query.Get("home"); homeParam != "" {
+		latLon := strings.Split(homeParam, ",")
+		if len(latLon) != 2 {
+			err = fmt.Errorf("`home` GET parameter must be formatted as LAT,LON")
+			return
+		}
+
+		for i, field := range []*float64{&params.homeLat, &params.homeLon} {
+			*field, err = strconv.ParseFloat(strings.TrimSpace(latLon[i]), 64)
+			if err != nil {
+				err = fmt.Errorf("cannot parse `home` GET parameter: %v", err)
+				return
+			}
+		}
+
+		_, err = graticuleFromPoint(params.homeLat, params.homeLon)
+		if err != nil {
+			err = fmt.Errorf("invalid `home` GET parameter: %v", err)
+			return
+		}
+		params.hasHome = true
+	}
+
+	status = http.StatusOK
+	return
+}
+
+// apiHashes converts geohash.Hashes into their JSON representation.
+func (params apiParams) apiHashes(hashes []geohash.Hash) (out []apiHash) {
+	out = make([]apiHash, 0, len(hashes))
+	for i, hash := range hashes {
+		year, month, day := hash.Date.Date()
+		validFrom := time.Date(year, month, day, 0, 0, 0, 0, params.target.tz)
+
+		h := apiHash{
+			Date:       hash.Date.Format("2006-01-02"),
+			DayOffset:  i,
+			Lat:        hash.Lat,
+			Lon:        hash.Lon,
+			Djia:       hash.Djia,
+			DjiaDate:   hash.DjiaDate.Format("2006-01-02"),
+			W30:        hash.W30Rule,
+			ValidFrom:  validFrom,
+			ValidUntil: validFrom.AddDate(0, 0, 1),
+		}
+		if params.hasHome {
+			dist := distance(params.homeLat, params.homeLon, hash.Lat, hash.Lon)
+			h.DistanceMeters = &dist
+		}
+		out = append(out, h)
+	}
+	return
+}
+
+// apiLocation converts a hashResult into its JSON representation.
+func (params apiParams) apiLocation(result hashResult, ctx context.Context) (location apiLocation) {
+	location.Location = result.name()
+	if n := result.neighbour; n != nil {
+		latOffset, lonOffset := n.latOffset, n.lonOffset
+		location.LatOffset = &latOffset
+		location.LonOffset = &lonOffset
+		location.Graticule = n.graticule.String()
+	}
+
+	location.Hashes = params.apiHashes(result.hashes)
+	location.Stale = result.stale
+
+	if result.err != nil {
+		location.Error = &apiError{
+			Message: result.err.Error(),
+			Reason:  errorReason(result.err, ctx),
+		}
+	}
+	return
+}
+
+// writeJson responds with the JSON encoded value and the given status code.
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Cannot write JSON response: %v", err)
+	}
+}
+
+// writeJsonError responds with an apiError and the given status code.
+func writeJsonError(w http.ResponseWriter, status int, err error, reason string) {
+	writeJson(w, status, struct {
+		Error apiError `json:"error"`
+	}{apiError{Message: err.Error(), Reason: reason}})
+}
+
+// writeResult responds with the single hashResult or an error, if there are no
+// geohashes available.
+//
+// The 30W case results in a 503 with a Retry-After header until the next NYSE
+// opening, an unavailable DJIA in a 502, and a timeout in a 504.
+func (params apiParams) writeResult(w http.ResponseWriter, result hashResult, ctx context.Context) {
+	if result.err == nil || len(result.hashes) > 0 {
+		writeJson(w, http.StatusOK, params.apiLocation(result, ctx))
+		return
+	}
+
+	reason := errorReason(result.err, ctx)
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(result.err, geohash.ErrW30NotYetAvailable):
+		status = http.StatusServiceUnavailable
+		retryAfter := time.Until(geohash.NextDowOpening(time.Now()))
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())+1))
+	case reason == "timeout":
+		status = http.StatusGatewayTimeout
+	case errors.Is(result.err, geohash.ErrDjiaUnavailable):
+		status = http.StatusBadGateway
+	}
+
+	writeJsonError(w, status, result.err, reason)
+}
+
+// geohashHandler serves the next geohashes of the requested graticule.
+func (api *apiServer) geohashHandler(w http.ResponseWriter, r *http.Request) {
+	params, status, err := api.parseParams(r, true)
+	if err != nil {
+		writeJsonError(w, status, err, "bad_request")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// The center was already validated while parsing the parameters.
+	center, _ := graticuleFromPoint(params.target.lat, params.target.lon)
+	results := computeHashes(neighbourhood(center, 0), false, params.date, ctx)
+	params.writeResult(w, results[0], ctx)
+}
+
+// globalhashHandler serves the next globalhashes.
+func (api *apiServer) globalhashHandler(w http.ResponseWriter, r *http.Request) {
+	params, status, err := api.parseParams(r, false)
+	if err != nil {
+		writeJsonError(w, status, err, "bad_request")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	results := computeHashes(nil, true, params.date, ctx)
+	params.writeResult(w, results[0], ctx)
+}
+
+// neighbourhoodHandler serves the next geohashes of the requested graticule's
+// neighbourhood and the globalhash, as the metrics. Errors are reported for
+// each location individually.
+func (api *apiServer) neighbourhoodHandler(w http.ResponseWriter, r *http.Request) {
+	params, status, err := api.parseParams(r, true)
+	if err != nil {
+		writeJsonError(w, status, err, "bad_request")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	center, _ := graticuleFromPoint(params.target.lat, params.target.lon)
+	results := computeHashes(neighbourhood(center, params.target.radius), params.target.globalhash, params.date, ctx)
+
+	locations := make([]apiLocation, 0, len(results))
+	for _, result := range results {
+		locations = append(locations, params.apiLocation(result, ctx))
+	}
+
+	writeJson(w, http.StatusOK, struct {
+		Locations []apiLocation `json:"locations"`
+	}{locations})
+}
+
+// register the API's handlers at the ServeMux. The event stream is only
+// available if events are set.
+func (api *apiServer) register(mux *http.ServeMux) {
+	handlers := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"/api/v1/geohash", api.geohashHandler},
+		{"/api/v1/globalhash", api.globalhashHandler},
+		{"/api/v1/neighbourhood", api.neighbourhoodHandler},
+	}
+	for _, h := range handlers {
+		mux.Handle(h.pattern, instrumentHandler(h.pattern, h.handler))
+	}
+
+	if api.events != nil {
+		mux.Handle("/api/v1/events", instrumentHandler("/api/v1/events", http.HandlerFunc(api.eventsHandler)))
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testConfig has a single "home" location in Berlin.
+func testConfig() *config {
+	conf := emptyConfig()
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	conf.targets["home"] = target{lat: 52.516272, lon: 13.377722, tz: tz, radius: 1, globalhash: true}
+	return conf
+}
+
+func TestApiGeohash(t *testing.T) {
+	setupTestProvider(t)
+
+	mux := http.NewServeMux()
+	(&apiServer{conf: testConfig()}).register(mux)
+
+	tests := []struct {
+		url    string
+		status int
+		reason string
+		hashes int
+		lat    float64
+		lon    float64
+		dist   bool
+		date   string
+	}{
+		// Pre-calculated weekend in Berlin.
+		{"/api/v1/geohash?lat=52.5&lon=13.4&tz=Europe/Berlin&date=2022-07-16", http.StatusOK, "", 3, 52.99178, 13.20571, false, "2022-07-16"},
+		{"/api/v1/geohash?lat=52.5&lon=13.4&tz=Europe/Berlin&date=2022-07-16&home=52.5,13.4", http.StatusOK, "", 3, 52.99178, 13.20571, true, "2022-07-16"},
+		{"/api/v1/geohash?target=home&date=2022-07-16", http.StatusOK, "", 3, 52.99178, 13.20571, true, "2022-07-16"},
+		{"/api/v1/globalhash?date=2022-07-16", http.StatusOK, "", 3, 88.520950, -105.946114, false, "2022-07-16"},
+
+		// A past day west of 30W is available, even in an eastern time zone.
+		{"/api/v1/geohash?lat=40.5&lon=-74.5&tz=Europe/Berlin&date=2022-07-15", http.StatusOK, "", 3, 40.11753, -74.38226, false, "2022-07-15"},
+		// Berlin's noon is too early in New York for a future day, 30W rule.
+		{"/api/v1/geohash?lat=40.5&lon=-74.5&tz=Europe/Berlin&date=2099-07-15", http.StatusServiceUnavailable, "w30_not_yet_available", 0, 0, 0, false, ""},
+		// Unknown DJIA
+		{"/api/v1/geohash?lat=52.5&lon=13.4&tz=Europe/Berlin&date=2022-07-20", http.StatusBadGateway, "djia_unavailable", 0, 0, 0, false, ""},
+
+		// Bad parameters
+		{"/api/v1/geohash?lat=52.5&tz=Europe/Berlin", http.StatusBadRequest, "bad_request", 0, 0, 0, false, ""},
+		{"/api/v1/geohash?lat=52.5&lon=13.4&tz=Europe/Marburg", http.StatusBadRequest, "bad_request", 0, 0, 0, false, ""},
+		{"/api/v1/geohash?lat=52.5&lon=13.4&tz=Europe/Berlin&date=yesterday", http.StatusBadRequest, "bad_request", 0, 0, 0, false, ""},
+		{"/api/v1/geohash?lat=52.5&lon=13.4&tz=Europe/Berlin&home=52.5", http.StatusBadRequest, "bad_request", 0, 0, 0, false, ""},
+		{"/api/v1/geohash?target=office", http.StatusNotFound, "bad_request", 0, 0, 0, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", test.url, nil))
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d instead of %d: %s", test.status, rec.Code, rec.Body)
+			}
+
+			if test.status != http.StatusOK {
+				var resp struct {
+					Error apiError `json:"error"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				} else if resp.Error.Reason != test.reason {
+					t.Fatalf("expected reason %q instead of %q", test.reason, resp.Error.Reason)
+				}
+
+				if test.status == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+					t.Fatal("Retry-After header is missing")
+				}
+				return
+			}
+
+			var location apiLocation
+			if err := json.NewDecoder(rec.Body).Decode(&location); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(location.Hashes) != test.hashes {
+				t.Fatalf("expected %d hashes instead of %d", test.hashes, len(location.Hashes))
+			}
+
+			hash := location.Hashes[0]
+			if math.Abs(hash.Lat-test.lat) > 0.00001 || math.Abs(hash.Lon-test.lon) > 0.00001 {
+				t.Fatalf("expected %f, %f instead of %f, %f", test.lat, test.lon, hash.Lat, hash.Lon)
+			}
+			if hash.Date != test.date || hash.DjiaDate != "2022-07-15" || hash.Djia != 30775.37 {
+				t.Fatalf("unexpected hash %#v", hash)
+			}
+			if (hash.DistanceMeters != nil) != test.dist {
+				t.Fatalf("expected distance = %t", test.dist)
+			}
+		})
+	}
+}
+
+func TestApiNeighbourhood(t *testing.T) {
+	setupTestProvider(t)
+
+	mux := http.NewServeMux()
+	(&apiServer{conf: testConfig()}).register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/neighbourhood?target=home&date=2022-07-16", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Locations []apiLocation `json:"locations"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Locations) != 10 {
+		t.Fatalf("expected 10 locations instead of %d", len(resp.Locations))
+	}
+	for _, location := range resp.Locations {
+		if location.Error != nil || len(location.Hashes) != 3 {
+			t.Fatalf("unexpected location %#v", location)
+		}
+	}
+	if center := resp.Locations[4]; center.Location != "center" || center.Graticule != "52,13" {
+		t.Fatalf("unexpected center %#v", center)
+	}
+	if global := resp.Locations[9]; global.Location != "global" || global.Graticule != "" {
+		t.Fatalf("unexpected globalhash %#v", global)
+	}
+}
@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the iCalendar feed, listing each upcoming nearby geohash
+// of a named location as an all-day event.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// calendarRefreshInterval is suggested to calendar clients. New geohashes are
+// calculated on each request, e.g., after the NYSE opening.
+const calendarRefreshInterval = "PT1H"
+
+// icsEscape escapes a TEXT value.
+//
+// https://datatracker.ietf.org/doc/html/rfc5545#section-3.3.11
+func icsEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// icsWriter writes content lines, folded after 75 octets.
+//
+// https://datatracker.ietf.org/doc/html/rfc5545#section-3.1
+type icsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// line writes a single content line. After the first error, nothing more is
+// written and the error is kept.
+func (iw *icsWriter) line(format string, a ...interface{}) {
+	if iw.err != nil {
+		return
+	}
+
+	const maxOctets = 75
+
+	line := fmt.Sprintf(format, a...)
+	var buf strings.Builder
+	for lineOctets := 0; line != ""; {
+		_, size := utf8.DecodeRuneInString(line)
+		if lineOctets+size > maxOctets {
+			// The continuation's leading space counts towards its octets.
+			buf.WriteString("\r\n ")
+			lineOctets = 1
+		}
+
+		buf.WriteString(line[:size])
+		lineOctets += size
+		line = line[size:]
+	}
+	buf.WriteString("\r\n")
+
+	_, iw.err = io.WriteString(iw.w, buf.String())
+}
+
+// writeCalendar writes an iCalendar with an all-day event for each hash of
+// the results within the target's maxDistance.
+//
+// Each event's UID is based on its expeditionName and thus stable between
+// requests. The now timestamp is used as the DTSTAMP.
+func writeCalendar(w io.Writer, name string, t target, results []hashResult, now time.Time) error {
+	iw := &icsWriter{w: w}
+
+	iw.line("BEGIN:VCALENDAR")
+	iw.line("VERSION:2.0")
+	iw.line("PRODID:-//oxzi//geohashing_exporter//EN")
+	iw.line("CALSCALE:GREGORIAN")
+	iw.line("X-WR-CALNAME:%s", icsEscape("Geohashes near "+name))
+	iw.line("REFRESH-INTERVAL;VALUE=DURATION:%s", calendarRefreshInterval)
+	iw.line("X-PUBLISHED-TTL:%s", calendarRefreshInterval)
+
+	for _, result := range results {
+		for _, hash := range result.hashes {
+			dist := distance(t.lat, t.lon, hash.Lat, hash.Lon)
+			if t.maxDistance > 0 && dist > t.maxDistance {
+				continue
+			}
+
+			expedition := expeditionName(hash.Date, result.neighbour)
+			pageName := strings.ReplaceAll(expedition, " ", "_")
+			description := fmt.Sprintf("%s\n%.1f km from %s\ngeo:%f,%f",
+				hashDescription(hash, result.neighbour), dist/1000, name, hash.Lat, hash.Lon)
+
+			year, month, day := hash.Date.Date()
+			start := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+			iw.line("BEGIN:VEVENT")
+			iw.line("UID:%s@geohashing_exporter", pageName)
+			iw.line("DTSTAMP:%s", now.UTC().Format("20060102T150405Z"))
+			iw.line("DTSTART;VALUE=DATE:%s", start.Format("20060102"))
+			iw.line("DTEND;VALUE=DATE:%s", start.AddDate(0, 0, 1).Format("20060102"))
+			iw.line("SUMMARY:%s", icsEscape(fmt.Sprintf("%s (%.1f km)", expedition, dist/1000)))
+			iw.line("DESCRIPTION:%s", icsEscape(description))
+			iw.line("GEO:%f;%f", hash.Lat, hash.Lon)
+			iw.line("URL:https://geohashing.site/geohashing/%s", pageName)
+			iw.line("TRANSP:TRANSPARENT")
+			iw.line("END:VEVENT")
+		}
+	}
+
+	iw.line("END:VCALENDAR")
+	return iw.err
+}
+
+// calendarHandler creates a HTTP handler function, serving the iCalendar feed
+// of the named location from the config, given by the `target` GET parameter.
+func calendarHandler(conf *config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		if name == "" {
+			http.Error(w, "`target` GET parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		t, ok := conf.targets[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		center, _ := graticuleFromPoint(t.lat, t.lon)
+		results := computeHashes(neighbourhood(center, t.radius), t.globalhash, time.Now().In(t.tz), ctx)
+
+		var buf bytes.Buffer
+		err := writeCalendar(&buf, name, t, results, time.Now())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot create calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		_, _ = buf.WriteTo(w)
+	}
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIcsEscape(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"foo", "foo"},
+		{"50,8; foo\\bar", `50\,8\; foo\\bar`},
+		{"foo\nbar", `foo\nbar`},
+	}
+
+	for _, test := range tests {
+		if out := icsEscape(test.in); out != test.out {
+			t.Fatalf("expected %q instead of %q", test.out, out)
+		}
+	}
+}
+
+func TestIcsWriterFolding(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"SUMMARY:short", "SUMMARY:short\r\n"},
+		{strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{strings.Repeat("a", 80), strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 5) + "\r\n"},
+		{strings.Repeat("a", 74) + "ä", strings.Repeat("a", 74) + "\r\n ä\r\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		iw := &icsWriter{w: &buf}
+		iw.line("%s", test.in)
+
+		if iw.err != nil {
+			t.Fatal(iw.err)
+		} else if out := buf.String(); out != test.out {
+			t.Fatalf("expected %q instead of %q", test.out, out)
+		}
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	setupTestProvider(t)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	target := target{lat: 52.516272, lon: 13.377722, tz: tz, radius: 1, globalhash: true, maxDistance: 60000}
+	center, _ := graticuleFromPoint(target.lat, target.lon)
+	date := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+	results := computeHashes(neighbourhood(center, target.radius), target.globalhash, date, context.Background())
+
+	var buf bytes.Buffer
+	if err := writeCalendar(&buf, "home", target, results, date); err != nil {
+		t.Fatal(err)
+	}
+	ics := strings.ReplaceAll(buf.String(), "\r\n ", "")
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:Geohashes near home\r\n",
+		"UID:2022-07-16_52_13@geohashing_exporter\r\n",
+		"DTSTAMP:20220716T100000Z\r\n",
+		"DTSTART;VALUE=DATE:20220716\r\n",
+		"DTEND;VALUE=DATE:20220717\r\n",
+		"GEO:52.991783;13.205705\r\n",
+		"URL:https://geohashing.site/geohashing/2022-07-16_52_13\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Fatalf("calendar misses %q:\n%s", line, ics)
+		}
+	}
+
+	if !strings.Contains(ics, `\ngeo:52.991783\,13.205705`) {
+		t.Fatalf("calendar misses geo: link:\n%s", ics)
+	}
+
+	// Each GEO must be within the maxDistance and the globalhash is far away.
+	events := 0
+	for _, line := range strings.Split(ics, "\r\n") {
+		if !strings.HasPrefix(line, "GEO:") {
+			continue
+		}
+		events++
+
+		var lat, lon float64
+		if _, err := fmt.Sscanf(line, "GEO:%g;%g", &lat, &lon); err != nil {
+			t.Fatal(err)
+		}
+		if dist := distance(target.lat, target.lon, lat, lon); dist > target.maxDistance {
+			t.Fatalf("event at %v,%v is %v m away", lat, lon, dist)
+		}
+	}
+	if events == 0 || events >= 30 || strings.Contains(ics, "global") {
+		t.Fatalf("unexpected %d events:\n%s", events, ics)
+	}
+	if begins := strings.Count(ics, "BEGIN:VEVENT"); begins != events {
+		t.Fatalf("expected %d events instead of %d", events, begins)
+	}
+}
+
+func TestCalendarHandlerBadRequest(t *testing.T) {
+	handler := calendarHandler(testConfig())
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/calendar.ics", http.StatusBadRequest},
+		{"/calendar.ics?target=office", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", test.url, nil))
+		if rec.Code != test.status {
+			t.Fatalf("expected status %d instead of %d for %s", test.status, rec.Code, test.url)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the Prometheus collector, calculating and caching the
+// geohashes around a position.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// geohashLabels are the variable labels of each geohash metric.
+var geohashLabels = []string{
+	// location describes which geohash is meant, as both the neighboring
+	// coordinates and the globalhash is also queried. Either "global" or a
+	// name created by neighbourName, e.g., "center", "nw", or "n2e".
+	"location",
+	// day_offset says how many days the geohash lays in the future.
+	"day_offset",
+	// lat_offset and lon_offset are the location's offset in graticules to
+	// the requested one. Empty for the globalhash.
+	"lat_offset",
+	"lon_offset",
+	// graticule is the location's absolute graticule, e.g., "50,8". Empty
+	// for the globalhash.
+	"graticule",
+	// date is the ISO 8601 date for which the geohash is valid, e.g.,
+	// "2022-07-16", being unambiguous in contrast to the day_offset.
+	"date",
+	// w30 is "true" if the 30W Time Zone Rule applied, i.e., east of 30W using
+	// the previous day's DJIA, and "false" otherwise.
+	"w30",
+}
+
+// geohashFamilies are the family names and help texts of all descriptors
+// created by newGeohashDesc, as required by gather.
+var geohashFamilies = make(map[*prometheus.Desc]struct{ name, help string })
+
+// newGeohashDesc creates a prometheus.Desc without constant labels and records
+// its family in geohashFamilies.
+func newGeohashDesc(name, help string, labels []string) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labels, nil)
+	geohashFamilies[desc] = struct{ name, help string }{name, help}
+	return desc
+}
+
+// Descriptors of all metrics exported by the geohashCollector.
+var (
+	geohashLatDesc = newGeohashDesc(
+		"geohashing_lat",
+		"Latitude of the geohash.",
+		geohashLabels)
+	geohashLonDesc = newGeohashDesc(
+		"geohashing_lon",
+		"Longitude of the geohash.",
+		geohashLabels)
+	geohashDistDesc = newGeohashDesc(
+		"geohashing_distance_meters",
+		"Great-circle distance between the requested position and the geohash.",
+		geohashLabels)
+	geohashAvailableDesc = newGeohashDesc(
+		"geohashing_available",
+		"Whether at least one geohash is available for this location.",
+		[]string{"location"})
+	geohashErrorDesc = newGeohashDesc(
+		"geohashing_error",
+		"Reason why the geohashes for this location could not be calculated.",
+		[]string{"location", "reason"})
+	geohashStaleDesc = newGeohashDesc(
+		"geohashing_stale",
+		"Whether the geohashes for this location are served from a previous calculation.",
+		[]string{"location"})
+	geohashLastSuccessDesc = newGeohashDesc(
+		"geohashing_last_successful_fetch_timestamp_seconds",
+		"Unix timestamp of the last successful calculation of this location's geohashes.",
+		[]string{"location"})
+
+	// The DJIA metrics are labeled by day_offset and w30, being "true" if the
+	// 30W Time Zone Rule applied, i.e., east of 30W using the previous DJIA.
+	geohashDjiaDesc = newGeohashDesc(
+		"geohashing_djia",
+		"Dow Jones Industrial Average opening value used to calculate the geohashes.",
+		[]string{"day_offset", "w30"})
+	geohashDjiaDateDesc = newGeohashDesc(
+		"geohashing_djia_date_timestamp_seconds",
+		"Date of the used Dow Jones Industrial Average as Unix timestamp at midnight UTC.",
+		[]string{"day_offset", "w30"})
+)
+
+// geohashCollector is a prometheus.Collector for the next geohashes in the
+// neighbourhood of a position and for the globalhash.
+//
+// Locations without available geohashes, e.g., due to the 30W rule, do not fail
+// the collection, but are reported in the geohashing_available and
+// geohashing_error metrics. If the calculation failed otherwise, e.g., during a
+// DJIA outage, still valid geohashes of a previous calculation are served and
+// marked by the geohashing_stale metric.
+type geohashCollector struct {
+	target target
+
+	// now is only to be altered for testing.
+	now func() time.Time
+}
+
+// newGeohashCollector for a target, which position must be within the valid
+// ranges as checked by graticuleFromPoint.
+func newGeohashCollector(t target) *geohashCollector {
+	return &geohashCollector{
+		target: t,
+		now:    time.Now,
+	}
+}
+
+// defaultCollectTimeout bounds the calculations of Collect, which lacks a
+// context. Requests should use gather with their own context instead.
+const defaultCollectTimeout = 10 * time.Second
+
+// Describe implements prometheus.Collector.
+func (collector *geohashCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- geohashLatDesc
+	ch <- geohashLonDesc
+	ch <- geohashDistDesc
+	ch <- geohashAvailableDesc
+	ch <- geohashErrorDesc
+	ch <- geohashStaleDesc
+	ch <- geohashLastSuccessDesc
+	ch <- geohashDjiaDesc
+	ch <- geohashDjiaDateDesc
+}
+
+// Collect implements prometheus.Collector, bounded by defaultCollectTimeout.
+func (collector *geohashCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCollectTimeout)
+	defer cancel()
+
+	collector.collect(ch, ctx)
+}
+
+// gather the metrics as metric families, bounded by the context. This allows
+// serving a request's metrics without a registry.
+func (collector *geohashCollector) gather(ctx context.Context) (families []*dto.MetricFamily, err error) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.collect(ch, ctx)
+		close(ch)
+	}()
+
+	familiesByDesc := make(map[*prometheus.Desc]*dto.MetricFamily)
+	for metric := range ch {
+		if err != nil {
+			continue
+		}
+
+		m := &dto.Metric{}
+		if err = metric.Write(m); err != nil {
+			continue
+		}
+
+		family, ok := familiesByDesc[metric.Desc()]
+		if !ok {
+			desc := geohashFamilies[metric.Desc()]
+			family = &dto.MetricFamily{
+				Name: proto.String(desc.name),
+				Help: proto.String(desc.help),
+				Type: dto.MetricType_GAUGE.Enum(),
+			}
+			familiesByDesc[metric.Desc()] = family
+			families = append(families, family)
+		}
+		family.Metric = append(family.Metric, m)
+	}
+	if err != nil {
+		families = nil
+		return
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+	return
+}
+
+// collect the metrics, bounded by the context.
+func (collector *geohashCollector) collect(ch chan<- prometheus.Metric, ctx context.Context) {
+	t := collector.target
+	center, err := graticuleFromPoint(t.lat, t.lon)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(geohashLatDesc, err)
+		return
+	}
+	localTime := collector.now().In(t.tz)
+
+	startTime := time.Now()
+	results := computeHashes(neighbourhood(center, t.radius), t.globalhash, localTime, ctx)
+	computationDuration.Observe(time.Since(startTime).Seconds())
+
+	// The DJIA values are the same for each location on the same side of 30W and
+	// thus only exported once.
+	type djiaKey struct {
+		dayOffset string
+		w30       string
+	}
+	djiaSeen := make(map[djiaKey]bool)
+
+	for _, result := range results {
+		if result.err != nil {
+			ch <- prometheus.MustNewConstMetric(geohashErrorDesc, prometheus.GaugeValue, 1,
+				result.name(), errorReason(result.err, ctx))
+
+			if !errors.Is(result.err, geohash.ErrW30NotYetAvailable) {
+				log.Printf("Requesting %s for %v,%v at %v failed: %v",
+					result.name(), t.lat, t.lon, t.tz, result.err)
+			}
+		}
+
+		if len(result.hashes) == 0 {
+			ch <- prometheus.MustNewConstMetric(geohashAvailableDesc, prometheus.GaugeValue, 0, result.name())
+			continue
+		}
+
+		stale := 0.0
+		if result.stale {
+			stale = 1.0
+		}
+
+		ch <- prometheus.MustNewConstMetric(geohashAvailableDesc, prometheus.GaugeValue, 1, result.name())
+		ch <- prometheus.MustNewConstMetric(geohashStaleDesc, prometheus.GaugeValue, stale, result.name())
+		ch <- prometheus.MustNewConstMetric(geohashLastSuccessDesc, prometheus.GaugeValue,
+			float64(result.lastSuccess.Unix()), result.name())
+
+		for i, hash := range result.hashes {
+			labels := []string{
+				result.name(),
+				fmt.Sprintf("%d", i),
+				"", "", "",
+				hash.Date.Format("2006-01-02"),
+				fmt.Sprintf("%t", hash.W30Rule),
+			}
+			if n := result.neighbour; n != nil {
+				labels[2] = fmt.Sprintf("%d", n.latOffset)
+				labels[3] = fmt.Sprintf("%d", n.lonOffset)
+				labels[4] = n.graticule.String()
+			}
+
+			ch <- prometheus.MustNewConstMetric(geohashLatDesc, prometheus.GaugeValue, hash.Lat, labels...)
+			ch <- prometheus.MustNewConstMetric(geohashLonDesc, prometheus.GaugeValue, hash.Lon, labels...)
+			ch <- prometheus.MustNewConstMetric(geohashDistDesc, prometheus.GaugeValue,
+				distance(t.lat, t.lon, hash.Lat, hash.Lon), labels...)
+
+			key := djiaKey{fmt.Sprintf("%d", i), fmt.Sprintf("%t", hash.W30Rule)}
+			if djiaSeen[key] {
+				continue
+			}
+			djiaSeen[key] = true
+
+			year, month, day := hash.DjiaDate.Date()
+			djiaDate := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+			ch <- prometheus.MustNewConstMetric(geohashDjiaDesc, prometheus.GaugeValue, hash.Djia, key.dayOffset, key.w30)
+			ch <- prometheus.MustNewConstMetric(geohashDjiaDateDesc, prometheus.GaugeValue,
+				float64(djiaDate.Unix()), key.dayOffset, key.w30)
+		}
+	}
+}
+
+// hashResult is the outcome of calculating the next geohashes for either a
+// neighbouring graticule or the globalhash.
+type hashResult struct {
+	// neighbour for which the geohashes were calculated; nil for the globalhash.
+	neighbour *neighbour
+	// hashes are the next geohashes as returned by
+	// geohash.GeoHashProvider.GeoNextHashes.
+	hashes []geohash.Hash
+	// err is set if the geohashes could not be calculated for this location.
+	err error
+
+	// stale is true if the calculation failed and the hashes are the still
+	// valid ones from the previous successful calculation at lastSuccess.
+	stale       bool
+	lastSuccess time.Time
+}
+
+// name of this result's location, e.g., "center" or "global".
+func (result hashResult) name() string {
+	if result.neighbour == nil {
+		return "global"
+	}
+	return result.neighbour.name
+}
+
+// hashesEntry are geohashes calculated at a certain time.
+type hashesEntry struct {
+	hashes []geohash.Hash
+	time   time.Time
+}
+
+// hashCache stores successfully calculated geohashes per location, local date
+// and time zone, together with the time of their calculation. Thus, entries are
+// implicitly invalidated on the next day.
+//
+// As the next geohashes are based on the DJIA values known for a date, they
+// won't change for the same date. Unavailable geohashes, e.g., due to the 30W
+// rule or an unpublished DJIA, will not be cached.
+type hashCache struct {
+	cache *lru.Cache[string, hashesEntry]
+}
+
+// newHashCache with a LRU cache of the given size.
+func newHashCache(size int) *hashCache {
+	cache, _ := lru.New[string, hashesEntry](size)
+	return &hashCache{cache: cache}
+}
+
+// hashCacheInstance is the hashCache used by computeHashes.
+var hashCacheInstance = newHashCache(4096)
+
+// get the cached geohashes for the location, e.g., a graticule or "global", at
+// the given date and the time of their calculation. Otherwise, they will be
+// calculated by f and, if successful, cached.
+func (cache *hashCache) get(location string, date time.Time, f func() ([]geohash.Hash, error)) (hashes []geohash.Hash, t time.Time, err error) {
+	cacheKey := fmt.Sprintf("%s/%s/%s", location, date.Format("2006-01-02"), date.Location())
+	entry, cacheHit := cache.cache.Get(cacheKey)
+	cacheRequests.WithLabelValues("hash", cacheResult(cacheHit)).Inc()
+	if cacheHit {
+		hashes, t = entry.hashes, entry.time
+		return
+	}
+
+	hashes, err = f()
+	if err != nil {
+		return
+	}
+
+	t = time.Now()
+	_ = cache.cache.Add(cacheKey, hashesEntry{hashes: hashes, time: t})
+	return
+}
+
+// geoHashProvider calculates all geohashes; might be replaced for testing.
+var geoHashProvider = geohash.GetGeoHashProvider()
+
+// lastHashes keeps the last successfully calculated geohashes per location,
+// e.g., a graticule or "global", to be served if a later calculation fails.
+type lastHashes struct {
+	cache *lru.Cache[string, hashesEntry]
+}
+
+// newLastHashes with a LRU cache of the given size.
+func newLastHashes(size int) *lastHashes {
+	cache, _ := lru.New[string, hashesEntry](size)
+	return &lastHashes{cache: cache}
+}
+
+// lastHashesInstance is the lastHashes used by computeHashes.
+var lastHashesInstance = newLastHashes(4096)
+
+// store successfully calculated geohashes for the location.
+func (last *lastHashes) store(location string, hashes []geohash.Hash, t time.Time) {
+	_ = last.cache.Add(location, hashesEntry{hashes: hashes, time: t})
+}
+
+// valid returns the stored geohashes for the location which are still valid at
+// the given date, i.e., the ones starting at date's day, and the time of their
+// calculation. If there are no such geohashes, ok is false.
+func (last *lastHashes) valid(location string, date time.Time) (hashes []geohash.Hash, t time.Time, ok bool) {
+	entry, ok := last.cache.Get(location)
+	if !ok {
+		return
+	}
+
+	today := date.Format("2006-01-02")
+	for i, hash := range entry.hashes {
+		if hash.Date.Format("2006-01-02") == today {
+			hashes = entry.hashes[i:]
+			break
+		}
+	}
+
+	t, ok = entry.time, len(hashes) > 0
+	return
+}
+
+// computeParallelism limits how many locations are calculated concurrently.
+const computeParallelism = 8
+
+// computeHashes calculates the next geohashes for all neighbours and, if
+// requested, the globalhash. Errors are reported for each location individually.
+// Except for the 30W rule, failed locations fall back to their still valid
+// geohashes from a previous calculation, if any.
+//
+// The locations are calculated concurrently, bounded by computeParallelism,
+// sharing the context's deadline. The results are ordered as the neighbours,
+// followed by the globalhash.
+func computeHashes(neighbours []neighbour, globalhash bool, date time.Time, ctx context.Context) (results []hashResult) {
+	provider := geoHashProvider
+
+	results = make([]hashResult, len(neighbours), len(neighbours)+1)
+	if globalhash {
+		results = append(results, hashResult{})
+	}
+	for i := range neighbours {
+		results[i].neighbour = &neighbours[i]
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, computeParallelism)
+
+	for i := range results {
+		wg.Add(1)
+		go func(result *hashResult) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				result.err = ctx.Err()
+				return
+			}
+
+			location := "global"
+			if result.neighbour != nil {
+				location = result.neighbour.graticule.String()
+			}
+
+			ctx, span := startSpan(ctx, "computeHashes "+location, otlpSpanKindInternal)
+			defer func() { span.finish(result.err) }()
+
+			result.hashes, result.lastSuccess, result.err = hashCacheInstance.get(location, date, func() ([]geohash.Hash, error) {
+				if result.neighbour == nil {
+					return provider.GlobalNextHashes(date, ctx)
+				}
+				return result.neighbour.graticule.geoNext(provider, date, ctx)
+			})
+
+			switch {
+			case result.err == nil:
+				lastHashesInstance.store(location, result.hashes, result.lastSuccess)
+
+			case errors.Is(result.err, geohash.ErrW30NotYetAvailable):
+				w30NotYetAvailable.Inc()
+
+			default:
+				result.hashes, result.lastSuccess, result.stale = lastHashesInstance.valid(location, date)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return
+}
+
+// errorReason maps an error from the geohash package to a short reason, to be
+// used as a label value.
+func errorReason(err error, ctx context.Context) string {
+	switch {
+	case errors.Is(err, geohash.ErrW30NotYetAvailable):
+		return "w30_not_yet_available"
+	case ctx.Err() != nil:
+		return "timeout"
+	case errors.Is(err, geohash.ErrDjiaUnavailable):
+		return "djia_unavailable"
+	default:
+		return "unknown"
+	}
+}
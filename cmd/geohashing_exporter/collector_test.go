@@ -0,0 +1,244 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testDjiaSource knows some DJIA values, as also used in the geohash package's
+// tests, and fails otherwise.
+func testDjiaSource(date time.Time, _ context.Context) (float64, error) {
+	switch date.Format("2006-01-02") {
+	case "2022-07-14":
+		return 30451.80, nil
+	case "2022-07-15":
+		return 30775.37, nil
+	default:
+		return 0.0, fmt.Errorf("%w: unsupported date %v", geohash.ErrDjiaUnavailable, date)
+	}
+}
+
+// setupTestProvider replaces the geoHashProvider by one backed by the
+// testDjiaSource and resets all caches until the test has finished.
+func setupTestProvider(t *testing.T) {
+	oldProvider, oldHashCache, oldLastHashes := geoHashProvider, hashCacheInstance, lastHashesInstance
+	t.Cleanup(func() {
+		geoHashProvider, hashCacheInstance, lastHashesInstance = oldProvider, oldHashCache, oldLastHashes
+	})
+
+	geoHashProvider = geohash.NewGeoHashProvider(testDjiaSource)
+	hashCacheInstance = newHashCache(64)
+	lastHashesInstance = newLastHashes(64)
+}
+
+func TestHashCache(t *testing.T) {
+	hashes := newHashCache(16)
+
+	calls := 0
+	f := func() ([]geohash.Hash, error) {
+		calls++
+		return []geohash.Hash{{Lat: 50.5, Lon: 8.5}}, nil
+	}
+	failing := func() ([]geohash.Hash, error) {
+		calls++
+		return nil, fmt.Errorf("nope")
+	}
+
+	locBerlin, _ := time.LoadLocation("Europe/Berlin")
+	locNy, _ := time.LoadLocation("America/New_York")
+	day1 := time.Date(2022, 7, 15, 10, 0, 0, 0, locBerlin)
+	day1Later := time.Date(2022, 7, 15, 23, 0, 0, 0, locBerlin)
+	day2 := time.Date(2022, 7, 16, 0, 30, 0, 0, locBerlin)
+
+	steps := []struct {
+		location string
+		date     time.Time
+		f        func() ([]geohash.Hash, error)
+		isErr    bool
+		calls    int
+	}{
+		{"50,8", day1, f, false, 1},
+		{"50,8", day1Later, f, false, 1},
+		{"50,8", day2, f, false, 2},
+		{"50,8", day1.In(locNy), f, false, 3},
+		{"51,8", day1, f, false, 4},
+		{"52,8", day1, failing, true, 5},
+		{"52,8", day1, failing, true, 6},
+		{"52,8", day1, f, false, 7},
+		{"52,8", day1, failing, false, 7},
+	}
+
+	var lastCalcTime time.Time
+	for i, step := range steps {
+		lastCalls := calls
+		locs, calcTime, err := hashes.get(step.location, step.date, step.f)
+		if (err != nil) != step.isErr {
+			t.Fatalf("step %d: expected isErr = %t, err = %v", i, step.isErr, err)
+		} else if !step.isErr && len(locs) != 1 {
+			t.Fatalf("step %d: unexpected result %v", i, locs)
+		}
+
+		if calls != step.calls {
+			t.Fatalf("step %d: expected %d calls instead of %d", i, step.calls, calls)
+		}
+
+		// A cache hit reports the time of the previous calculation.
+		if !step.isErr && calls == lastCalls && !calcTime.Equal(lastCalcTime) {
+			t.Fatalf("step %d: expected calculation time %v instead of %v", i, lastCalcTime, calcTime)
+		}
+		lastCalcTime = calcTime
+	}
+}
+
+func TestLastHashes(t *testing.T) {
+	locBerlin, _ := time.LoadLocation("Europe/Berlin")
+	day := func(d int) time.Time {
+		return time.Date(2022, 7, d, 10, 0, 0, 0, locBerlin)
+	}
+
+	last := newLastHashes(16)
+	calcTime := day(16)
+	last.store("50,8", []geohash.Hash{
+		{Lat: 50.1, Date: day(16)},
+		{Lat: 50.2, Date: day(17)},
+		{Lat: 50.3, Date: day(18)},
+	}, calcTime)
+
+	tests := []struct {
+		location string
+		date     time.Time
+		ok       bool
+		lats     []float64
+	}{
+		{"50,8", day(16), true, []float64{50.1, 50.2, 50.3}},
+		{"50,8", day(17), true, []float64{50.2, 50.3}},
+		{"50,8", day(18).Add(12 * time.Hour), true, []float64{50.3}},
+		{"50,8", day(19), false, nil},
+		{"51,8", day(16), false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s;%v", test.location, test.date), func(t *testing.T) {
+			hashes, hashesTime, ok := last.valid(test.location, test.date)
+			if ok != test.ok {
+				t.Fatalf("expected ok = %t", test.ok)
+			} else if !ok {
+				return
+			}
+
+			if !hashesTime.Equal(calcTime) {
+				t.Fatalf("expected time %v instead of %v", calcTime, hashesTime)
+			}
+			if len(hashes) != len(test.lats) {
+				t.Fatalf("expected %d hashes instead of %d", len(test.lats), len(hashes))
+			}
+			for i, hash := range hashes {
+				if hash.Lat != test.lats[i] {
+					t.Fatalf("offset %d: expected %f instead of %f", i, test.lats[i], hash.Lat)
+				}
+			}
+		})
+	}
+}
+
+func TestGeohashCollector(t *testing.T) {
+	setupTestProvider(t)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	collector := newGeohashCollector(target{lat: 52.516272, lon: 13.377722, tz: tz, radius: 0, globalhash: false})
+	collector.now = func() time.Time { return time.Date(2022, time.July, 16, 12, 0, 0, 0, tz) }
+
+	// The stale and last success metrics are omitted as they depend on the time
+	// of the calculation.
+	metricNames := []string{
+		"geohashing_available",
+		"geohashing_djia",
+		"geohashing_djia_date_timestamp_seconds",
+		"geohashing_lat",
+		"geohashing_lon",
+	}
+	expected := `
+# HELP geohashing_available Whether at least one geohash is available for this location.
+# TYPE geohashing_available gauge
+geohashing_available{location="center"} 1
+# HELP geohashing_djia Dow Jones Industrial Average opening value used to calculate the geohashes.
+# TYPE geohashing_djia gauge
+geohashing_djia{day_offset="0",w30="true"} 30775.37
+geohashing_djia{day_offset="1",w30="true"} 30775.37
+geohashing_djia{day_offset="2",w30="true"} 30775.37
+# HELP geohashing_djia_date_timestamp_seconds Date of the used Dow Jones Industrial Average as Unix timestamp at midnight UTC.
+# TYPE geohashing_djia_date_timestamp_seconds gauge
+geohashing_djia_date_timestamp_seconds{day_offset="0",w30="true"} 1.6578432e+09
+geohashing_djia_date_timestamp_seconds{day_offset="1",w30="true"} 1.6578432e+09
+geohashing_djia_date_timestamp_seconds{day_offset="2",w30="true"} 1.6578432e+09
+# HELP geohashing_lat Latitude of the geohash.
+# TYPE geohashing_lat gauge
+geohashing_lat{date="2022-07-16",day_offset="0",graticule="52,13",lat_offset="0",location="center",lon_offset="0",w30="true"} 52.99178305648397
+geohashing_lat{date="2022-07-17",day_offset="1",graticule="52,13",lat_offset="0",location="center",lon_offset="0",w30="true"} 52.112949578470044
+geohashing_lat{date="2022-07-18",day_offset="2",graticule="52,13",lat_offset="0",location="center",lon_offset="0",w30="true"} 52.87523066231304
+# HELP geohashing_lon Longitude of the geohash.
+# TYPE geohashing_lon gauge
+geohashing_lon{date="2022-07-16",day_offset="0",graticule="52,13",lat_offset="0",location="center",lon_offset="0",w30="true"} 13.2057052390222
+geohashing_lon{date="2022-07-17",day_offset="1",graticule="52,13",lat_offset="0",location="center",lon_offset="0",w30="true"} 13.071434899217897
+geohashing_lon{date="2022-07-18",day_offset="2",graticule="52,13",lat_offset="0",location="center",lon_offset="0",w30="true"} 13.859380175553854
+`
+
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), metricNames...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Gathering with a context results in the same metrics.
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return collector.gather(context.Background())
+	})
+	err = testutil.GatherAndCompare(gatherer, strings.NewReader(expected), metricNames...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An expired context is reported as a timeout, even if the DJIA source
+	// would block.
+	release := make(chan struct{})
+	defer close(release)
+	geoHashProvider = geohash.NewGeoHashProvider(func(date time.Time, ctx context.Context) (float64, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return 0.0, geohash.ErrDjiaUnavailable
+	})
+	hashCacheInstance = newHashCache(64)
+	lastHashesInstance = newLastHashes(64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	families, err := collector.gather(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				found = found || (family.GetName() == "geohashing_error" && label.GetValue() == "timeout")
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected a timeout error in %v", families)
+	}
+}
@@ -0,0 +1,461 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the YAML configuration file, defining named locations to
+// be probed.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// target describes the position for which geohashes should be collected.
+type target struct {
+	// lat and lon of the position; its graticule is the neighbourhood's center.
+	lat, lon float64
+	// tz is the time zone of the position, to determine the current date.
+	tz *time.Location
+	// radius of the neighbourhood, see neighbourhood.
+	radius int
+	// globalhash should also be collected.
+	globalhash bool
+	// maxDistance in meters for geohashes to be considered nearby, e.g., for the
+	// calendar feed. Zero means no limit besides the radius.
+	maxDistance float64
+}
+
+// locationConfig is a named location within the configuration file.
+type locationConfig struct {
+	// Lat and Lon are pointers to distinguish missing coordinates from zero.
+	Lat    *float64 `yaml:"lat"`
+	Lon    *float64 `yaml:"lon"`
+	Tz     string   `yaml:"tz"`
+	Radius *int     `yaml:"radius"`
+
+	// Globalhash might be disabled for this location; defaults to true.
+	Globalhash *bool `yaml:"globalhash"`
+
+	// MaxDistanceKm limits nearby geohashes, e.g., for the calendar feed.
+	MaxDistanceKm float64 `yaml:"max_distance_km"`
+}
+
+// alertRuleConfig is a distance rule, evaluated by the alertEngine.
+type alertRuleConfig struct {
+	// Name of the rule, used as the alertname label.
+	Name string `yaml:"name"`
+	// Locations to be evaluated by their name; defaults to all locations.
+	Locations []string `yaml:"locations"`
+	// MaxDistanceKm from the location for a hash to fire an alert.
+	MaxDistanceKm float64 `yaml:"max_distance_km"`
+	// Globalhash selects the globalhash instead of the neighbouring geohashes.
+	Globalhash bool `yaml:"globalhash"`
+}
+
+// alertsConfig enables the alertEngine, sending notifications to a webhook.
+type alertsConfig struct {
+	// WebhookUrl receives Alertmanager-compatible webhook payloads.
+	WebhookUrl string `yaml:"webhook_url"`
+	// Interval between evaluations; defaults to five minutes.
+	Interval time.Duration     `yaml:"interval"`
+	Rules    []alertRuleConfig `yaml:"rules"`
+}
+
+// digestConfig enables the daily email digest, sent via an SMTP relay.
+type digestConfig struct {
+	// SmtpAddr of the relay, e.g., "mail.example.com:587". STARTTLS will be
+	// used if supported by the relay.
+	SmtpAddr string `yaml:"smtp_addr"`
+	// Username and Password for PLAIN authentication; optional.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+
+	// Locations to be listed by their name; defaults to all locations.
+	Locations []string `yaml:"locations"`
+
+	// Time of day to send the digest as HH:MM in the Tz; defaults to 07:00 in
+	// the local time zone.
+	Time string `yaml:"time"`
+	Tz   string `yaml:"tz"`
+	// StateFile remembers the date of the last digest; optional. Then, a
+	// missed digest is sent after a restart, while a sent one is not repeated.
+	StateFile string `yaml:"state_file"`
+
+	// hour, minute, and tz are parsed from Time and Tz by validate.
+	hour, minute int
+	tz           *time.Location
+}
+
+// mqttConfig enables the MQTT publisher.
+type mqttConfig struct {
+	// Addr of the broker, e.g., "localhost:1883".
+	Addr     string `yaml:"addr"`
+	Tls      bool   `yaml:"tls"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// ClientId defaults to "geohashing_exporter".
+	ClientId string `yaml:"client_id"`
+
+	// TopicPrefix for the states, defaults to "geohashing".
+	TopicPrefix string `yaml:"topic_prefix"`
+	// Discovery of Home Assistant might be disabled; defaults to true.
+	Discovery *bool `yaml:"discovery"`
+	// DiscoveryPrefix of Home Assistant, defaults to "homeassistant". After the
+	// validation, an empty prefix disables the discovery.
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+
+	// Interval between checks for changed values; defaults to five minutes.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// matrixConfig enables the Matrix bot.
+type matrixConfig struct {
+	// Homeserver URL, e.g., "https://matrix.example.org".
+	Homeserver string `yaml:"homeserver"`
+	// AccessToken of the bot's already joined user.
+	AccessToken string `yaml:"access_token"`
+	// UserId of the bot, e.g., "@geohashing:example.org", to ignore its own
+	// messages and to remember its posted hashes in its account data.
+	UserId string `yaml:"user_id"`
+	// Rooms to post new hashes to and to answer commands in, by their id.
+	Rooms []string `yaml:"rooms"`
+	// Location by its name, used for distances and the time zone.
+	Location string `yaml:"location"`
+}
+
+// otlpConfig enables the OpenTelemetry export of metrics and traces.
+type otlpConfig struct {
+	// Endpoint of the OTLP/HTTP receiver, e.g., "http://localhost:4318". The
+	// signals are sent to its /v1/metrics and /v1/traces paths.
+	Endpoint string `yaml:"endpoint"`
+	// Headers to be sent with each request, e.g., for authentication.
+	Headers map[string]string `yaml:"headers"`
+	// ServiceName resource attribute; defaults to "geohashing_exporter".
+	ServiceName string `yaml:"service_name"`
+
+	// Interval between metric exports; defaults to one minute.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// config is the YAML configuration file's root.
+//
+//	locations:
+//	  home:
+//	    lat: 50.810222
+//	    lon: 8.767017
+//	    tz: Europe/Berlin
+//	    radius: 2
+//	    globalhash: false
+//	    max_distance_km: 30
+//	alerts:
+//	  webhook_url: http://localhost:5001/
+//	  rules:
+//	    - name: GeohashNearby
+//	      max_distance_km: 30
+//	digest:
+//	  smtp_addr: mail.example.com:587
+//	  from: geohashing@example.com
+//	  to: [me@example.com]
+//	  time: "07:00"
+//	mqtt:
+//	  addr: localhost:1883
+//	matrix:
+//	  homeserver: https://matrix.example.org
+//	  access_token: secret
+//	  rooms: ["!abcdefghijklmnopqr:example.org"]
+//	  location: home
+//	otlp:
+//	  endpoint: http://localhost:4318
+type config struct {
+	Locations map[string]locationConfig `yaml:"locations"`
+	Alerts    *alertsConfig             `yaml:"alerts"`
+	Digest    *digestConfig             `yaml:"digest"`
+	Mqtt      *mqttConfig               `yaml:"mqtt"`
+	Matrix    *matrixConfig             `yaml:"matrix"`
+	Otlp      *otlpConfig               `yaml:"otlp"`
+
+	// targets are the validated Locations, populated by loadConfig.
+	targets map[string]target
+}
+
+// emptyConfig is used if no configuration file was given.
+func emptyConfig() *config {
+	return &config{targets: make(map[string]target)}
+}
+
+// loadConfig from a YAML file and validate each location.
+func loadConfig(path string) (conf *config, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	conf = emptyConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(conf)
+	if errors.Is(err, io.EOF) {
+		// An empty configuration file is fine.
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("cannot parse configuration %q: %w", path, err)
+		return
+	}
+
+	for name, location := range conf.Locations {
+		t, targetErr := location.target()
+		if targetErr != nil {
+			err = fmt.Errorf("invalid location %q: %w", name, targetErr)
+			return
+		}
+		conf.targets[name] = t
+	}
+
+	if conf.Alerts != nil {
+		err = conf.Alerts.validate(conf.targets)
+		if err != nil {
+			err = fmt.Errorf("invalid alerts: %w", err)
+			return
+		}
+	}
+
+	if conf.Digest != nil {
+		err = conf.Digest.validate(conf.targets)
+		if err != nil {
+			err = fmt.Errorf("invalid digest: %w", err)
+			return
+		}
+	}
+
+	if conf.Mqtt != nil {
+		err = conf.Mqtt.validate()
+		if err != nil {
+			err = fmt.Errorf("invalid mqtt: %w", err)
+			return
+		}
+	}
+
+	if conf.Matrix != nil {
+		err = conf.Matrix.validate(conf.targets)
+		if err != nil {
+			err = fmt.Errorf("invalid matrix: %w", err)
+			return
+		}
+	}
+
+	if conf.Otlp != nil {
+		err = conf.Otlp.validate()
+		if err != nil {
+			err = fmt.Errorf("invalid otlp: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// target validates this location and converts it into a target.
+func (location locationConfig) target() (t target, err error) {
+	if location.Lat == nil {
+		err = fmt.Errorf("lat is missing")
+		return
+	} else if location.Lon == nil {
+		err = fmt.Errorf("lon is missing")
+		return
+	}
+
+	t.lat, t.lon = *location.Lat, *location.Lon
+	_, err = graticuleFromPoint(t.lat, t.lon)
+	if err != nil {
+		return
+	}
+
+	if location.Tz == "" {
+		err = fmt.Errorf("tz is missing")
+		return
+	}
+	t.tz, err = time.LoadLocation(location.Tz)
+	if err != nil {
+		return
+	}
+
+	t.radius = 1
+	if location.Radius != nil {
+		t.radius = *location.Radius
+	}
+	if t.radius < 0 || t.radius > maxRadius {
+		err = fmt.Errorf("radius must be between 0 and %d", maxRadius)
+		return
+	}
+
+	t.globalhash = true
+	if location.Globalhash != nil {
+		t.globalhash = *location.Globalhash
+	}
+
+	if location.MaxDistanceKm < 0 {
+		err = fmt.Errorf("max_distance_km must not be negative")
+		return
+	}
+	t.maxDistance = location.MaxDistanceKm * 1000
+	return
+}
+
+// validate the alerts against the known targets and set defaults.
+func (alerts *alertsConfig) validate(targets map[string]target) error {
+	u, err := url.Parse(alerts.WebhookUrl)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook_url must be a HTTP or HTTPS URL")
+	}
+
+	if alerts.Interval == 0 {
+		alerts.Interval = 5 * time.Minute
+	} else if alerts.Interval < time.Second {
+		return fmt.Errorf("interval must be at least one second")
+	}
+
+	for i, rule := range alerts.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		} else if rule.MaxDistanceKm <= 0 {
+			return fmt.Errorf("rule %q requires a positive max_distance_km", rule.Name)
+		}
+
+		for _, location := range rule.Locations {
+			if _, ok := targets[location]; !ok {
+				return fmt.Errorf("rule %q has an unknown location %q", rule.Name, location)
+			}
+		}
+	}
+	return nil
+}
+
+// validate the digest against the known targets.
+func (digest *digestConfig) validate(targets map[string]target) error {
+	if _, _, err := net.SplitHostPort(digest.SmtpAddr); err != nil {
+		return fmt.Errorf("smtp_addr must be a HOST:PORT: %v", err)
+	}
+
+	if _, err := mail.ParseAddress(digest.From); err != nil {
+		return fmt.Errorf("invalid from: %v", err)
+	}
+	if len(digest.To) == 0 {
+		return fmt.Errorf("to requires at least one recipient")
+	}
+	for _, to := range digest.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid to: %v", err)
+		}
+	}
+
+	for _, location := range digest.Locations {
+		if _, ok := targets[location]; !ok {
+			return fmt.Errorf("unknown location %q", location)
+		}
+	}
+
+	if digest.Time == "" {
+		digest.Time = "07:00"
+	}
+	at, err := time.Parse("15:04", digest.Time)
+	if err != nil {
+		return fmt.Errorf("time must be formatted as HH:MM: %v", err)
+	}
+	digest.hour, digest.minute = at.Hour(), at.Minute()
+
+	if digest.Tz == "" {
+		digest.Tz = "Local"
+	}
+	digest.tz, err = time.LoadLocation(digest.Tz)
+	if err != nil {
+		return fmt.Errorf("invalid tz: %v", err)
+	}
+	return nil
+}
+
+// validate the MQTT configuration and set defaults.
+func (mqtt *mqttConfig) validate() error {
+	if _, _, err := net.SplitHostPort(mqtt.Addr); err != nil {
+		return fmt.Errorf("addr must be a HOST:PORT: %v", err)
+	}
+
+	if mqtt.ClientId == "" {
+		mqtt.ClientId = "geohashing_exporter"
+	}
+	if mqtt.TopicPrefix == "" {
+		mqtt.TopicPrefix = "geohashing"
+	}
+
+	if mqtt.Discovery != nil && !*mqtt.Discovery {
+		mqtt.DiscoveryPrefix = ""
+	} else if mqtt.DiscoveryPrefix == "" {
+		mqtt.DiscoveryPrefix = "homeassistant"
+	}
+
+	if mqtt.Interval == 0 {
+		mqtt.Interval = 5 * time.Minute
+	} else if mqtt.Interval < time.Second {
+		return fmt.Errorf("interval must be at least one second")
+	}
+	return nil
+}
+
+// validate the Matrix configuration against the known targets.
+func (matrix *matrixConfig) validate(targets map[string]target) error {
+	u, err := url.Parse(matrix.Homeserver)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("homeserver must be a HTTP or HTTPS URL")
+	}
+
+	if matrix.AccessToken == "" {
+		return fmt.Errorf("access_token is missing")
+	}
+	if matrix.UserId == "" {
+		return fmt.Errorf("user_id is missing")
+	}
+	if len(matrix.Rooms) == 0 {
+		return fmt.Errorf("rooms requires at least one room")
+	}
+	if _, ok := targets[matrix.Location]; !ok {
+		return fmt.Errorf("unknown location %q", matrix.Location)
+	}
+	return nil
+}
+
+// validate the OTLP configuration and set defaults.
+func (otlp *otlpConfig) validate() error {
+	u, err := url.Parse(otlp.Endpoint)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("endpoint must be a HTTP or HTTPS URL")
+	}
+
+	if otlp.ServiceName == "" {
+		otlp.ServiceName = "geohashing_exporter"
+	}
+
+	if otlp.Interval == 0 {
+		otlp.Interval = time.Minute
+	} else if otlp.Interval < time.Second {
+		return fmt.Errorf("interval must be at least one second")
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		isErr bool
+	}{
+		{"empty", "", false},
+		{"valid", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+  office:
+    lat: -0.5
+    lon: -78.5
+    tz: America/Guayaquil
+    radius: 3
+    globalhash: false
+    max_distance_km: 50
+`, false},
+		{"unknown field", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+    foo: bar
+`, true},
+		{"missing tz", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+`, true},
+		{"missing lat", `
+locations:
+  home:
+    lon: 8.767017
+    tz: Europe/Berlin
+`, true},
+		{"missing lon", `
+locations:
+  home:
+    lat: 50.810222
+    tz: Europe/Berlin
+`, true},
+		{"zero coordinates", `
+locations:
+  null_island:
+    lat: 0
+    lon: 0
+    tz: UTC
+`, false},
+		{"invalid tz", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Marburg
+`, true},
+		{"invalid lat", `
+locations:
+  home:
+    lat: 100
+    lon: 8.767017
+    tz: Europe/Berlin
+`, true},
+		{"invalid radius", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+    radius: 100
+`, true},
+		{"alerts", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+alerts:
+  webhook_url: http://localhost:5001/
+  interval: 1m
+  rules:
+    - name: GeohashNearby
+      max_distance_km: 30
+    - name: GlobalhashNearby
+      locations: [home]
+      max_distance_km: 500
+      globalhash: true
+`, false},
+		{"alerts without webhook", `
+alerts:
+  rules:
+    - name: GeohashNearby
+      max_distance_km: 30
+`, true},
+		{"alerts with unknown location", `
+alerts:
+  webhook_url: http://localhost:5001/
+  rules:
+    - name: GeohashNearby
+      locations: [home]
+      max_distance_km: 30
+`, true},
+		{"alerts without distance", `
+alerts:
+  webhook_url: http://localhost:5001/
+  rules:
+    - name: GeohashNearby
+`, true},
+		{"digest", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+digest:
+  smtp_addr: mail.example.com:587
+  username: geohashing
+  password: hunter2
+  from: Geohashing <geohashing@example.com>
+  to: [me@example.com]
+  locations: [home]
+  time: "06:30"
+  tz: Europe/Berlin
+  state_file: /var/lib/geohashing_exporter/digest
+`, false},
+		{"digest without port", `
+digest:
+  smtp_addr: mail.example.com
+  from: geohashing@example.com
+  to: [me@example.com]
+`, true},
+		{"digest without recipients", `
+digest:
+  smtp_addr: mail.example.com:587
+  from: geohashing@example.com
+`, true},
+		{"digest with invalid time", `
+digest:
+  smtp_addr: mail.example.com:587
+  from: geohashing@example.com
+  to: [me@example.com]
+  time: "7 am"
+`, true},
+		{"digest with invalid tz", `
+digest:
+  smtp_addr: mail.example.com:587
+  from: geohashing@example.com
+  to: [me@example.com]
+  tz: Europe/Marburg
+`, true},
+		{"digest with unknown location", `
+digest:
+  smtp_addr: mail.example.com:587
+  from: geohashing@example.com
+  to: [me@example.com]
+  locations: [home]
+`, true},
+		{"mqtt", `
+mqtt:
+  addr: localhost:1883
+  username: geohashing
+  password: hunter2
+  discovery: false
+  interval: 1m
+`, false},
+		{"mqtt without port", `
+mqtt:
+  addr: localhost
+`, true},
+		{"matrix", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+matrix:
+  homeserver: https://matrix.example.org
+  access_token: secret
+  user_id: "@geohashing:example.org"
+  rooms: ["!abcdefghijklmnopqr:example.org"]
+  location: home
+`, false},
+		{"matrix without rooms", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+matrix:
+  homeserver: https://matrix.example.org
+  access_token: secret
+  user_id: "@geohashing:example.org"
+  location: home
+`, true},
+		{"matrix without user_id", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+matrix:
+  homeserver: https://matrix.example.org
+  access_token: secret
+  rooms: ["!abcdefghijklmnopqr:example.org"]
+  location: home
+`, true},
+		{"matrix with unknown location", `
+matrix:
+  homeserver: https://matrix.example.org
+  access_token: secret
+  rooms: ["!abcdefghijklmnopqr:example.org"]
+  location: home
+`, true},
+		{"otlp", `
+otlp:
+  endpoint: http://localhost:4318
+  headers:
+    Authorization: Bearer secret
+  interval: 30s
+`, false},
+		{"otlp without scheme", `
+otlp:
+  endpoint: localhost:4318
+`, true},
+		{"invalid max_distance_km", `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+    max_distance_km: -1
+`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadConfig(path)
+			if (err != nil) != test.isErr {
+				t.Fatalf("expected isErr = %t, err = %v", test.isErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	data := `
+locations:
+  home:
+    lat: 50.810222
+    lon: 8.767017
+    tz: Europe/Berlin
+  parents:
+    lat: 52.516272
+    lon: 13.377722
+    tz: Europe/Berlin
+    radius: 0
+    globalhash: false
+    max_distance_km: 12.5
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if home := conf.targets["home"]; home.radius != 1 || !home.globalhash || home.tz.String() != "Europe/Berlin" || home.maxDistance != 0 {
+		t.Fatalf("unexpected home %#v", home)
+	}
+	if parents := conf.targets["parents"]; parents.radius != 0 || parents.globalhash || parents.maxDistance != 12500 {
+		t.Fatalf("unexpected parents %#v", parents)
+	}
+}
@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the email digest, listing the upcoming geohashes of the
+// configured locations daily at a configured time.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// digestSender composes and sends the digest daily, scheduled by run.
+type digestSender struct {
+	conf *config
+	// now is the current time, only to be altered for testing.
+	now func() time.Time
+
+	// minBackoff and maxBackoff between failed attempts to send a digest.
+	minBackoff, maxBackoff time.Duration
+}
+
+// newDigestSender for the config, which must have a digest.
+func newDigestSender(conf *config) *digestSender {
+	return &digestSender{
+		conf:       conf,
+		now:        time.Now,
+		minBackoff: 30 * time.Second,
+		maxBackoff: 15 * time.Minute,
+	}
+}
+
+// digestEntry is a single hash within the digest.
+type digestEntry struct {
+	expedition string
+	hash       geohash.Hash
+	distance   float64
+}
+
+// osmLink to the coordinates on the OpenStreetMap.
+func osmLink(lat, lon float64) string {
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%f&mlon=%f#map=12/%f/%f", lat, lon, lat, lon)
+}
+
+// writeLocation lists the hashes of a named location, ranked by distance.
+// Geohashes beyond the location's maxDistance are omitted, while the
+// globalhash is always listed, unless disabled.
+func (d *digestSender) writeLocation(buf *bytes.Buffer, name string, t target, ctx context.Context) {
+	center, _ := graticuleFromPoint(t.lat, t.lon)
+	results := computeHashes(neighbourhood(center, t.radius), t.globalhash, d.now().In(t.tz), ctx)
+
+	var entries []digestEntry
+	var failed []string
+	for _, result := range results {
+		if result.err != nil && len(result.hashes) == 0 {
+			failed = append(failed, fmt.Sprintf("%s (%s)", result.name(), errorReason(result.err, ctx)))
+			continue
+		}
+
+		for _, hash := range result.hashes {
+			dist := distance(t.lat, t.lon, hash.Lat, hash.Lon)
+			if result.neighbour != nil && t.maxDistance > 0 && dist > t.maxDistance {
+				continue
+			}
+
+			entries = append(entries, digestEntry{
+				expedition: expeditionName(hash.Date, result.neighbour),
+				hash:       hash,
+				distance:   dist,
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].distance < entries[j].distance })
+
+	fmt.Fprintf(buf, "%s (%f, %f)\n\n", name, t.lat, t.lon)
+	if len(entries) == 0 {
+		fmt.Fprintf(buf, "No geohashes are available.\n")
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(buf, "* %s, %.1f km: %f, %f\n  %s\n  DJIA %.2f of %s\n",
+			entry.expedition, entry.distance/1000, entry.hash.Lat, entry.hash.Lon,
+			osmLink(entry.hash.Lat, entry.hash.Lon),
+			entry.hash.Djia, entry.hash.DjiaDate.Format("2006-01-02"))
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(buf, "\nUnavailable: %s\n", strings.Join(failed, ", "))
+	}
+	fmt.Fprintf(buf, "\n")
+}
+
+// compose the digest's message, including its headers.
+func (d *digestSender) compose(ctx context.Context) []byte {
+	digest := d.conf.Digest
+	now := d.now().In(digest.tz)
+
+	names := digest.Locations
+	if len(names) == 0 {
+		for name := range d.conf.targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var body bytes.Buffer
+	for _, name := range names {
+		d.writeLocation(&body, name, d.conf.targets[name], ctx)
+	}
+
+	var msg bytes.Buffer
+	headers := []struct {
+		key, value string
+	}{
+		{"From", digest.From},
+		{"To", strings.Join(digest.To, ", ")},
+		{"Subject", "Geohashes for " + now.Format("2006-01-02")},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", header.key, header.value)
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+
+	return msg.Bytes()
+}
+
+// sendMail via the SMTP relay as smtp.SendMail, but bounded by the context.
+// STARTTLS will be used if supported by the relay.
+func sendMail(addr string, auth smtp.Auth, from string, to []string, msg []byte, ctx context.Context) (err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return
+	}
+
+	// A stalled relay is interrupted by closing the connection.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("relay does not support AUTH")
+		}
+		err = c.Auth(auth)
+		if err != nil {
+			return
+		}
+	}
+
+	err = c.Mail(from)
+	if err != nil {
+		return
+	}
+	for _, addr := range to {
+		err = c.Rcpt(addr)
+		if err != nil {
+			return
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return
+	}
+	err = w.Close()
+	if err != nil {
+		return
+	}
+	return c.Quit()
+}
+
+// send the digest via the SMTP relay.
+func (d *digestSender) send(ctx context.Context) error {
+	digest := d.conf.Digest
+
+	// The envelope requires plain addresses, while the headers might also
+	// contain names.
+	from, err := mail.ParseAddress(digest.From)
+	if err != nil {
+		return err
+	}
+	var to []string
+	for _, addr := range digest.To {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return err
+		}
+		to = append(to, parsed.Address)
+	}
+
+	var auth smtp.Auth
+	if digest.Username != "" {
+		host, _, _ := net.SplitHostPort(digest.SmtpAddr)
+		auth = smtp.PlainAuth("", digest.Username, digest.Password, host)
+	}
+
+	return sendMail(digest.SmtpAddr, auth, from.Address, to, d.compose(ctx), ctx)
+}
+
+// due returns when the next digest should be sent, based on the date of the
+// last one as YYYY-MM-DD, and this digest's date.
+//
+// Without a last date, today's digest is only due if its time is still ahead.
+// Otherwise, a missed digest of today is due immediately.
+func (d *digestSender) due(now time.Time, lastSent string) (at time.Time, date string) {
+	digest := d.conf.Digest
+
+	year, month, day := now.In(digest.tz).Date()
+	at = time.Date(year, month, day, digest.hour, digest.minute, 0, 0, digest.tz)
+	if (lastSent == "" && at.Before(now)) || lastSent >= at.Format("2006-01-02") {
+		at = at.AddDate(0, 0, 1)
+	}
+
+	date = at.Format("2006-01-02")
+	return
+}
+
+// touchStateFile creates the digest's state file, if missing. This must happen
+// before dropping privileges, which only allow existing files.
+func touchStateFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// loadState returns the date of the last digest from the StateFile, if any.
+func (d *digestSender) loadState() string {
+	if d.conf.Digest.StateFile == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(d.conf.Digest.StateFile)
+	if err != nil {
+		log.Printf("Cannot read the digest's state: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// storeState writes the date of the last digest to the StateFile, if any.
+func (d *digestSender) storeState(date string) {
+	if d.conf.Digest.StateFile == "" {
+		return
+	}
+
+	err := os.WriteFile(d.conf.Digest.StateFile, []byte(date+"\n"), 0o600)
+	if err != nil {
+		log.Printf("Cannot write the digest's state: %v", err)
+	}
+}
+
+// run the sender until the context is done, sending the digest daily. A failed
+// digest is retried until its day is over. Only sent digests are stored in the
+// state, while a given up one is skipped until the next restart.
+func (d *digestSender) run(ctx context.Context) {
+	lastDate := d.loadState()
+	for {
+		at, date := d.due(d.now(), lastDate)
+		log.Printf("Next digest is scheduled for %v", at)
+		if !sleep(at.Sub(d.now()), ctx) {
+			return
+		}
+
+		// The deadline is relative to the current time, which might be altered.
+		dayEnd := time.Date(at.Year(), at.Month(), at.Day()+1, 0, 0, 0, 0, at.Location())
+		deadline := time.Now().Add(dayEnd.Sub(d.now()))
+
+		err := retryUntil("sending the digest of "+date, deadline, d.minBackoff, d.maxBackoff,
+			func(ctx context.Context) error {
+				sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+				defer cancel()
+
+				return d.send(sendCtx)
+			}, ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		lastDate = date
+		if err == nil {
+			log.Printf("Sent the digest of %s", date)
+			d.storeState(date)
+		}
+	}
+}
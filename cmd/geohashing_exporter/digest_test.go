@@ -0,0 +1,352 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message received by the smtpStandIn.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn accepts SMTP sessions on a local port, supporting just enough
+// commands for sendMail, and passes their messages to the channel. The first
+// failures sessions are rejected, as by an unavailable relay.
+func smtpStandIn(t *testing.T, failures int) (addr string, messages <-chan smtpMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	ch := make(chan smtpMessage, 8)
+	go func() {
+		for session := 0; ; session++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			if session < failures {
+				_ = textproto.NewConn(conn).PrintfLine("421 localhost unavailable")
+				_ = conn.Close()
+				continue
+			}
+			smtpSession(conn, ch)
+		}
+	}()
+
+	return listener.Addr().String(), ch
+}
+
+// smtpSession handles a single SMTP session of the smtpStandIn.
+func smtpSession(conn net.Conn, ch chan<- smtpMessage) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(format string, a ...interface{}) { _ = text.PrintfLine(format, a...) }
+
+	var msg smtpMessage
+	reply("220 localhost stand-in")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg.from = strings.TrimSuffix(strings.TrimPrefix(line, "MAIL FROM:<"), ">")
+			reply("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			reply("250 OK")
+			ch <- msg
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestDigestSender(t *testing.T) {
+	setupTestProvider(t)
+
+	addr, messages := smtpStandIn(t, 0)
+
+	conf := testConfig()
+	home := conf.targets["home"]
+	home.maxDistance = 52000
+	conf.targets["home"] = home
+	conf.Digest = &digestConfig{
+		SmtpAddr: addr,
+		From:     "Geohashing <geohashing@example.com>",
+		To:       []string{"alice@example.com", "Bob <bob@example.com>"},
+	}
+	if err := conf.Digest.validate(conf.targets); err != nil {
+		t.Fatal(err)
+	}
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	d := newDigestSender(conf)
+	d.now = func() time.Time { return time.Date(2022, time.July, 16, 12, 0, 0, 0, tz) }
+
+	if err := d.send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg smtpMessage
+	select {
+	case msg = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was received")
+	}
+
+	if msg.from != "geohashing@example.com" || fmt.Sprint(msg.to) != "[alice@example.com bob@example.com]" {
+		t.Fatalf("unexpected envelope %q to %q", msg.from, msg.to)
+	}
+
+	for _, part := range []string{
+		"Subject: Geohashes for 2022-07-16\n",
+		"To: alice@example.com, Bob <bob@example.com>\n",
+		"home (52.516272, 13.377722)\n",
+		"* 2022-07-17 52 13, 49.4 km: 52.112950, 13.071435\n",
+		"  https://www.openstreetmap.org/?mlat=52.112950&mlon=13.071435#map=12/52.112950/13.071435\n",
+		"  DJIA 30775.37 of 2022-07-15\n",
+		"* 2022-07-16 global, 4247.9 km: 88.520950, -105.946114\n",
+	} {
+		if !strings.Contains(msg.data, part) {
+			t.Fatalf("message misses %q:\n%s", part, msg.data)
+		}
+	}
+
+	// Hashes are ranked by distance, while the 2022-07-16 center is too far away.
+	if strings.Contains(msg.data, "2022-07-16 52 13") {
+		t.Fatalf("message contains a too far away geohash:\n%s", msg.data)
+	}
+	if strings.Index(msg.data, "2022-07-17 52 13") > strings.Index(msg.data, "2022-07-18 52 13") ||
+		strings.Index(msg.data, "2022-07-18 52 13") > strings.Index(msg.data, "2022-07-16 global") {
+		t.Fatalf("hashes are not ranked by distance:\n%s", msg.data)
+	}
+}
+
+func TestDigestSenderDue(t *testing.T) {
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	conf := testConfig()
+	conf.Digest = &digestConfig{
+		SmtpAddr: "localhost:25",
+		From:     "geohashing@example.com",
+		To:       []string{"alice@example.com"},
+		Time:     "07:00",
+		Tz:       "Europe/Berlin",
+	}
+	if err := conf.Digest.validate(conf.targets); err != nil {
+		t.Fatal(err)
+	}
+	d := newDigestSender(conf)
+
+	tests := []struct {
+		now      time.Time
+		lastSent string
+		at       time.Time
+	}{
+		// Without a state, only upcoming digests are sent, e.g., after a restart.
+		{time.Date(2022, time.July, 16, 6, 0, 0, 0, tz), "", time.Date(2022, time.July, 16, 7, 0, 0, 0, tz)},
+		{time.Date(2022, time.July, 16, 12, 0, 0, 0, tz), "", time.Date(2022, time.July, 17, 7, 0, 0, 0, tz)},
+		// Today's digest was already sent.
+		{time.Date(2022, time.July, 16, 12, 0, 0, 0, tz), "2022-07-16", time.Date(2022, time.July, 17, 7, 0, 0, 0, tz)},
+		// Today's missed digest is due immediately.
+		{time.Date(2022, time.July, 16, 12, 0, 0, 0, tz), "2022-07-15", time.Date(2022, time.July, 16, 7, 0, 0, 0, tz)},
+		{time.Date(2022, time.July, 16, 12, 0, 0, 0, tz), "2022-07-01", time.Date(2022, time.July, 16, 7, 0, 0, 0, tz)},
+		{time.Date(2022, time.July, 16, 6, 0, 0, 0, tz), "2022-07-15", time.Date(2022, time.July, 16, 7, 0, 0, 0, tz)},
+		// The time is local to the digest's time zone.
+		{time.Date(2022, time.July, 16, 4, 30, 0, 0, time.UTC), "", time.Date(2022, time.July, 16, 7, 0, 0, 0, tz)},
+		{time.Date(2022, time.July, 16, 5, 30, 0, 0, time.UTC), "", time.Date(2022, time.July, 17, 7, 0, 0, 0, tz)},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v;%s", test.now, test.lastSent), func(t *testing.T) {
+			at, date := d.due(test.now, test.lastSent)
+			if !at.Equal(test.at) {
+				t.Fatalf("expected %v instead of %v", test.at, at)
+			} else if date != test.at.Format("2006-01-02") {
+				t.Fatalf("unexpected date %q", date)
+			}
+		})
+	}
+}
+
+func TestDigestSenderRun(t *testing.T) {
+	setupTestProvider(t)
+
+	// The relay is unavailable at first, while the digest is retried.
+	addr, messages := smtpStandIn(t, 2)
+	stateFile := filepath.Join(t.TempDir(), "digest")
+	if err := os.WriteFile(stateFile, []byte("2022-07-15\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testConfig()
+	conf.Digest = &digestConfig{
+		SmtpAddr:  addr,
+		From:      "geohashing@example.com",
+		To:        []string{"alice@example.com"},
+		Time:      "07:00",
+		Tz:        "Europe/Berlin",
+		StateFile: stateFile,
+	}
+	if err := conf.Digest.validate(conf.targets); err != nil {
+		t.Fatal(err)
+	}
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	d := newDigestSender(conf)
+	d.now = func() time.Time { return time.Date(2022, time.July, 16, 12, 0, 0, 0, tz) }
+	d.minBackoff, d.maxBackoff = 10*time.Millisecond, 10*time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+
+	// Today's digest was missed and is sent immediately.
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg.data, "Subject: Geohashes for 2022-07-16\n") {
+			t.Fatalf("unexpected message:\n%s", msg.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was received")
+	}
+
+	// Afterwards, it is remembered and the next digest is due tomorrow.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, err := os.ReadFile(stateFile)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(state) == "2022-07-16\n" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("unexpected state %q", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestDigestSenderRunGivingUp(t *testing.T) {
+	setupTestProvider(t)
+
+	addr, messages := smtpStandIn(t, 1_000_000)
+	stateFile := filepath.Join(t.TempDir(), "digest")
+	if err := os.WriteFile(stateFile, []byte("2022-07-15\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := testConfig()
+	conf.Digest = &digestConfig{
+		SmtpAddr:  addr,
+		From:      "geohashing@example.com",
+		To:        []string{"alice@example.com"},
+		Time:      "07:00",
+		Tz:        "Europe/Berlin",
+		StateFile: stateFile,
+	}
+	if err := conf.Digest.validate(conf.targets); err != nil {
+		t.Fatal(err)
+	}
+
+	// The day is over shortly, after which the digest is given up.
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	d := newDigestSender(conf)
+	start := time.Now()
+	d.now = func() time.Time {
+		return time.Date(2022, time.July, 16, 23, 59, 59, 800_000_000, tz).Add(time.Since(start))
+	}
+	d.minBackoff, d.maxBackoff = 10*time.Millisecond, 10*time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected message:\n%s", msg.data)
+	case <-time.After(500 * time.Millisecond):
+	}
+	cancel()
+	<-done
+
+	// The failed digest is not remembered as sent.
+	if state, err := os.ReadFile(stateFile); err != nil {
+		t.Fatal(err)
+	} else if string(state) != "2022-07-15\n" {
+		t.Fatalf("unexpected state %q", state)
+	}
+}
+
+func TestSendMailStalled(t *testing.T) {
+	// This relay accepts connections, but never responds.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		<-stop
+		_ = conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = sendMail(listener.Addr().String(), nil, "geohashing@example.com", []string{"alice@example.com"}, []byte("Hello"), ctx)
+	if err == nil {
+		t.Fatal("expected an error for a stalled relay")
+	} else if time.Since(start) > 5*time.Second {
+		t.Fatalf("sending took %v", time.Since(start))
+	}
+}
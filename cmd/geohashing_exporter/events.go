@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the Server-Sent Events stream, pushing newly available
+// geohashes to subscribed clients as soon as the prefetcher learns a new DJIA.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// hashEvents distributes new DJIA dates from the prefetcher to all subscribed
+// event streams. Each stream calculates the new hashes for its own location.
+type hashEvents struct {
+	// keepAlive interval to send comments, keeping idle connections open.
+	keepAlive time.Duration
+
+	subscribers     map[chan time.Time]struct{}
+	subscribersLock sync.Mutex
+}
+
+// newHashEvents to be registered as a prefetcher's djiaListener by notify.
+func newHashEvents() *hashEvents {
+	return &hashEvents{
+		keepAlive:   30 * time.Second,
+		subscribers: make(map[chan time.Time]struct{}),
+	}
+}
+
+// subscribe for new DJIA dates until the returned cancel function is called.
+func (events *hashEvents) subscribe() (ch chan time.Time, cancel func()) {
+	ch = make(chan time.Time, 8)
+
+	events.subscribersLock.Lock()
+	events.subscribers[ch] = struct{}{}
+	events.subscribersLock.Unlock()
+
+	cancel = func() {
+		events.subscribersLock.Lock()
+		delete(events.subscribers, ch)
+		events.subscribersLock.Unlock()
+	}
+	return
+}
+
+// notify all subscribers about a new DJIA, implementing djiaListener. A slow
+// subscriber with a full channel will miss this date.
+func (events *hashEvents) notify(date time.Time, _ float64) {
+	events.subscribersLock.Lock()
+	defer events.subscribersLock.Unlock()
+
+	for ch := range events.subscribers {
+		select {
+		case ch <- date:
+		default:
+		}
+	}
+}
+
+// unlockedHashResults calculates the hashes which became available by the DJIA
+// of the given date for the neighbours and, if requested, the globalhash.
+//
+// West of 30W, these are the hashes starting at the DJIA's date and, east of
+// 30W, starting on the following day. Both are followed by the weekend or
+// holiday days based on the same DJIA. Results without such hashes are omitted,
+// while the others are ordered as the neighbours, followed by the globalhash.
+// The first error of any result, e.g., an unavailable DJIA, is returned as err.
+func unlockedHashResults(neighbours []neighbour, globalhash bool, djiaDate time.Time, ctx context.Context) (results []hashResult, err error) {
+	year, month, day := djiaDate.Date()
+	djiaDay := djiaDate.Format("2006-01-02")
+
+	// Noon in New York is after the NYSE opening. Thus, the hashes west of 30W
+	// based on this DJIA are available.
+	w30Date := time.Date(year, month, day, 12, 0, 0, 0, geohash.NyseTz())
+	e30Date := w30Date.AddDate(0, 0, 1)
+
+	isE30 := func(n neighbour) bool {
+		lonArea, _ := graticuleArea(n.graticule.lonIdx)
+		return lonArea > -30
+	}
+
+	var w30Neighbours, e30Neighbours []neighbour
+	for _, n := range neighbours {
+		if isE30(n) {
+			e30Neighbours = append(e30Neighbours, n)
+		} else {
+			w30Neighbours = append(w30Neighbours, n)
+		}
+	}
+
+	w30Results := computeHashes(w30Neighbours, false, w30Date, ctx)
+	e30Results := computeHashes(e30Neighbours, globalhash, e30Date, ctx)
+
+	// Restore the neighbours' order, while the globalhash remains last.
+	ordered := make([]hashResult, 0, len(w30Results)+len(e30Results))
+	for _, n := range neighbours {
+		if isE30(n) {
+			ordered, e30Results = append(ordered, e30Results[0]), e30Results[1:]
+		} else {
+			ordered, w30Results = append(ordered, w30Results[0]), w30Results[1:]
+		}
+	}
+	ordered = append(ordered, e30Results...)
+
+	for _, result := range ordered {
+		if result.err != nil && err == nil {
+			err = fmt.Errorf("%s: %w", result.name(), result.err)
+		}
+
+		var hashes []geohash.Hash
+		for _, hash := range result.hashes {
+			if hash.DjiaDate.Format("2006-01-02") == djiaDay {
+				hashes = append(hashes, hash)
+			}
+		}
+
+		if len(hashes) > 0 {
+			result.hashes = hashes
+			results = append(results, result)
+		}
+	}
+	return
+}
+
+// eventsHandler streams a `geohash` event for each location with new hashes
+// after each new DJIA, as calculated by unlockedHashResults. The parameters are
+// the same as for the geohashHandler, while the date is ignored.
+//
+// Each event's data is an apiLocation, containing only the new hashes, and its
+// id is the DJIA's date.
+func (api *apiServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	params, status, err := api.parseParams(r, true)
+	if err != nil {
+		writeJsonError(w, status, err, "bad_request")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJsonError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"), "unknown")
+		return
+	}
+
+	ch, cancel := api.events.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(api.events.keepAlive)
+	defer keepAlive.Stop()
+
+	center, _ := graticuleFromPoint(params.target.lat, params.target.lon)
+	neighbours := neighbourhood(center, params.target.radius)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case djiaDate := <-ch:
+			ctx, cancelCtx := context.WithTimeout(r.Context(), 10*time.Second)
+			// Failed locations are omitted, while the others are still streamed.
+			results, _ := unlockedHashResults(neighbours, params.target.globalhash, djiaDate, ctx)
+
+			for _, result := range results {
+				data, err := json.Marshal(params.apiLocation(result, ctx))
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: geohash\nid: %s\ndata: %s\n\n", djiaDate.Format("2006-01-02"), data)
+			}
+			cancelCtx()
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandler(t *testing.T) {
+	setupTestProvider(t)
+
+	nyc, _ := time.LoadLocation("America/New_York")
+
+	events := newHashEvents()
+
+	mux := http.NewServeMux()
+	(&apiServer{conf: testConfig(), events: events}).register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events?lat=52.5&lon=13.4&tz=Europe/Berlin&radius=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %v, %v", resp.Status, resp.Header)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (lines []string) {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	// The subscription is confirmed by a comment, after which notifications
+	// will be received.
+	if lines := readEvent(); len(lines) != 1 || lines[0] != ": subscribed" {
+		t.Fatalf("unexpected subscription %v", lines)
+	}
+
+	events.notify(time.Date(2022, time.July, 15, 9, 30, 0, 0, nyc), 30775.37)
+
+	for _, location := range []string{"center", "global"} {
+		lines := readEvent()
+		if len(lines) != 3 || lines[0] != "event: geohash" || lines[1] != "id: 2022-07-15" {
+			t.Fatalf("unexpected event %v", lines)
+		}
+
+		var loc apiLocation
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &loc); err != nil {
+			t.Fatal(err)
+		}
+		if loc.Location != location || len(loc.Hashes) != 3 {
+			t.Fatalf("unexpected location %#v", loc)
+		}
+		for _, hash := range loc.Hashes {
+			if hash.DjiaDate != "2022-07-15" {
+				t.Fatalf("unexpected hash %#v", hash)
+			}
+		}
+	}
+}
+
+func TestEventsHandlerDisabled(t *testing.T) {
+	mux := http.NewServeMux()
+	(&apiServer{conf: testConfig()}).register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/events?target=home", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d instead of %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestUnlockedHashResults(t *testing.T) {
+	setupTestProvider(t)
+
+	nyc, _ := time.LoadLocation("America/New_York")
+	w30Center, _ := graticuleFromPoint(40.5, -74.5)
+	e30Center, _ := graticuleFromPoint(52.5, 13.4)
+	neighbours := []neighbour{neighbourhood(w30Center, 0)[0], neighbourhood(e30Center, 0)[0]}
+
+	tests := []struct {
+		name     string
+		djiaDate time.Time
+		// dates of the unlocked hashes for the W30 and E30 graticule and the
+		// globalhash.
+		dates [][]string
+	}{
+		// On a normal weekday, W30 gets today's hash and E30 tomorrow's.
+		{"thursday", time.Date(2022, time.July, 14, 9, 30, 0, 0, nyc), [][]string{
+			{"2022-07-14"},
+			{"2022-07-15"},
+			{"2022-07-15"},
+		}},
+		// Friday's DJIA is also used for the weekend.
+		{"friday", time.Date(2022, time.July, 15, 9, 30, 0, 0, nyc), [][]string{
+			{"2022-07-15", "2022-07-16", "2022-07-17"},
+			{"2022-07-16", "2022-07-17", "2022-07-18"},
+			{"2022-07-16", "2022-07-17", "2022-07-18"},
+		}},
+		// Unknown DJIA
+		{"wednesday", time.Date(2022, time.July, 13, 9, 30, 0, 0, nyc), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := unlockedHashResults(neighbours, true, test.djiaDate, context.Background())
+			if (err != nil) != (test.dates == nil) {
+				t.Fatalf("unexpected error %v", err)
+			} else if len(results) != len(test.dates) {
+				t.Fatalf("expected %d results instead of %d: %#v", len(test.dates), len(results), results)
+			}
+
+			for i, result := range results {
+				if i < 2 && result.neighbour.graticule != neighbours[i].graticule {
+					t.Fatalf("result %d: unexpected graticule %v", i, result.neighbour.graticule)
+				} else if i == 2 && result.neighbour != nil {
+					t.Fatalf("result %d: expected the globalhash", i)
+				}
+
+				var dates []string
+				for _, hash := range result.hashes {
+					dates = append(dates, hash.Date.Format("2006-01-02"))
+					if hash.DjiaDate.Format("2006-01-02") != test.djiaDate.Format("2006-01-02") {
+						t.Fatalf("result %d: unexpected DJIA date of %#v", i, hash)
+					}
+				}
+				if strings.Join(dates, " ") != strings.Join(test.dates[i], " ") {
+					t.Fatalf("result %d: expected %v instead of %v", i, test.dates[i], dates)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the GPX and KML export of upcoming geohashes as waypoints
+// for GPS devices or Google Earth, both as an HTTP endpoint and the `export`
+// subcommand.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// waypoint is a single geohash to be exported.
+type waypoint struct {
+	// name of the waypoint, following the geohashing.site expedition naming,
+	// e.g., "2022-07-16 52 13" or "2022-07-16 global".
+	name string
+	// description with the graticule, date, and the used DJIA.
+	description string
+	lat, lon    float64
+}
+
+// expeditionName for a hash, as used by geohashing.site, e.g.,
+// "2022-07-16 52 13", "2022-07-16 -0 -0", or "2022-07-16 global".
+func expeditionName(date time.Time, n *neighbour) string {
+	name := date.Format("2006-01-02") + " "
+	if n == nil {
+		return name + "global"
+	}
+	return name + strings.Replace(n.graticule.String(), ",", " ", 1)
+}
+
+// hashDescription for humans, e.g., "Geohash of graticule 52,13 for
+// 2022-07-16, DJIA 30775.37 of 2022-07-15".
+func hashDescription(hash geohash.Hash, n *neighbour) string {
+	kind := "Globalhash"
+	if n != nil {
+		kind = fmt.Sprintf("Geohash of graticule %v", n.graticule)
+	}
+
+	return fmt.Sprintf("%s for %s, DJIA %.2f of %s",
+		kind, hash.Date.Format("2006-01-02"), hash.Djia, hash.DjiaDate.Format("2006-01-02"))
+}
+
+// waypoints of all available hashes within the results. Locations without any
+// available geohash are omitted.
+func waypoints(results []hashResult) (wpts []waypoint) {
+	for _, result := range results {
+		for _, hash := range result.hashes {
+			wpts = append(wpts, waypoint{
+				name:        expeditionName(hash.Date, result.neighbour),
+				description: hashDescription(hash, result.neighbour),
+				lat:         hash.Lat,
+				lon:         hash.Lon,
+			})
+		}
+	}
+	return
+}
+
+// gpxWaypoint is a GPX 1.1 wptType.
+type gpxWaypoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Name string  `xml:"name"`
+	Desc string  `xml:"desc"`
+	Type string  `xml:"type"`
+}
+
+// gpx is a GPX 1.1 document's root.
+//
+// https://www.topografix.com/GPX/1/1/
+type gpx struct {
+	XMLName   xml.Name      `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Name      string        `xml:"metadata>name"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+// writeGpx writes the waypoints as a GPX 1.1 document.
+func writeGpx(w io.Writer, wpts []waypoint) error {
+	doc := gpx{
+		Version:   "1.1",
+		Creator:   "geohashing_exporter",
+		Name:      "Geohashes",
+		Waypoints: make([]gpxWaypoint, 0, len(wpts)),
+	}
+	for _, wpt := range wpts {
+		doc.Waypoints = append(doc.Waypoints, gpxWaypoint{
+			Lat:  wpt.lat,
+			Lon:  wpt.lon,
+			Name: wpt.name,
+			Desc: wpt.description,
+			Type: "Geohash",
+		})
+	}
+	return writeXml(w, doc)
+}
+
+// kmlPlacemark is a KML Placemark with a Point.
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+// kml is a KML 2.2 document's root.
+//
+// https://developers.google.com/kml/documentation/kmlreference
+type kml struct {
+	XMLName    xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// writeKml writes the waypoints as a KML 2.2 document.
+func writeKml(w io.Writer, wpts []waypoint) error {
+	doc := kml{
+		Name:       "Geohashes",
+		Placemarks: make([]kmlPlacemark, 0, len(wpts)),
+	}
+	for _, wpt := range wpts {
+		doc.Placemarks = append(doc.Placemarks, kmlPlacemark{
+			Name:        wpt.name,
+			Description: wpt.description,
+			// KML orders the coordinates as longitude, latitude.
+			Coordinates: fmt.Sprintf("%f,%f", wpt.lon, wpt.lat),
+		})
+	}
+	return writeXml(w, doc)
+}
+
+// writeXml writes an indented XML document, including its header.
+func writeXml(w io.Writer, doc interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// exportFormat is a supported export file format.
+type exportFormat struct {
+	write       func(io.Writer, []waypoint) error
+	contentType string
+}
+
+// exportFormats by their name, also being the file extension.
+var exportFormats = map[string]exportFormat{
+	"gpx": {writeGpx, "application/gpx+xml"},
+	"kml": {writeKml, "application/vnd.google-earth.kml+xml"},
+}
+
+// exportHandler creates a HTTP handler function, serving the next geohashes in
+// the named exportFormat for the same parameters as the metricsHandler.
+func exportHandler(formatName string) http.HandlerFunc {
+	format := exportFormats[formatName]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := metricsHandlerParseParams(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r))
+		defer cancel()
+
+		// The center was already validated while parsing the parameters.
+		center, _ := graticuleFromPoint(t.lat, t.lon)
+		results := computeHashes(neighbourhood(center, t.radius), t.globalhash, time.Now().In(t.tz), ctx)
+
+		// Buffer the document to be able to report errors.
+		var buf bytes.Buffer
+		err = format.write(&buf, waypoints(results))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot create %s: %v", formatName, err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"geohashes.%s\"", formatName))
+		_, _ = buf.WriteTo(w)
+	}
+}
+
+// exportCommand implements the `export` subcommand, writing the next geohashes
+// for the given position to out or to the file given by the `-o` flag. Before
+// requesting anything, privileges are dropped by harden, e.g., toLeastPrivilege.
+//
+//	geohashing_exporter export -lat 50.81 -lon 8.77 -tz Europe/Berlin -format kml
+func exportCommand(args []string, out io.Writer, harden func(rwFiles ...string)) (err error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	lat := flags.Float64("lat", 0, "Latitude of the position or graticule; required")
+	lon := flags.Float64("lon", 0, "Longitude of the position or graticule; required")
+	tzName := flags.String("tz", "Local", "Time zone of the position")
+	radius := flags.Int("radius", 1, "Radius of neighbouring graticules")
+	globalhash := flags.Bool("globalhash", true, "Also export the globalhash")
+	dateParam := flags.String("date", "", "Date as YYYY-MM-DD; defaults to today")
+	formatName := flags.String("format", "gpx", "Output format, either gpx or kml")
+	outFile := flags.String("o", "", "Output file; defaults to stdout")
+	err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	// Both coordinates are required, as zero is a valid one.
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["lat"] {
+		return fmt.Errorf("-lat is required")
+	} else if !given["lon"] {
+		return fmt.Errorf("-lon is required")
+	}
+
+	format, ok := exportFormats[*formatName]
+	if !ok {
+		return fmt.Errorf("unsupported format %q", *formatName)
+	}
+
+	center, err := graticuleFromPoint(*lat, *lon)
+	if err != nil {
+		return err
+	}
+	if *radius < 0 || *radius > maxRadius {
+		return fmt.Errorf("radius must be between 0 and %d", maxRadius)
+	}
+
+	tz, err := time.LoadLocation(*tzName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	date := now.In(tz)
+	if *dateParam != "" {
+		date, err = parseDay(*dateParam, tz, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The output file must be opened before dropping privileges.
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// A failed close might have truncated the written file.
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = f
+	}
+
+	harden()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results := computeHashes(neighbourhood(center, *radius), *globalhash, date, ctx)
+	for _, result := range results {
+		if result.err != nil {
+			log.Printf("Requesting %s failed: %v", result.name(), result.err)
+		}
+	}
+
+	wpts := waypoints(results)
+	if len(wpts) == 0 {
+		return errors.New("no geohashes are available")
+	}
+	return format.write(out, wpts)
+}
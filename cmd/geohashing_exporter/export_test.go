@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpeditionName(t *testing.T) {
+	date := time.Date(2022, time.July, 16, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		lat  float64
+		lon  float64
+		name string
+	}{
+		{52.5, 13.4, "2022-07-16 52 13"},
+		{-0.5, -0.5, "2022-07-16 -0 -0"},
+		{-33.9, 151.2, "2022-07-16 -33 151"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := graticuleFromPoint(test.lat, test.lon)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if name := expeditionName(date, &neighbour{graticule: g}); name != test.name {
+				t.Fatalf("expected %q instead of %q", test.name, name)
+			}
+		})
+	}
+
+	if name := expeditionName(date, nil); name != "2022-07-16 global" {
+		t.Fatalf("expected globalhash instead of %q", name)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	setupTestProvider(t)
+
+	args := []string{"-lat", "52.5", "-lon", "13.4", "-tz", "Europe/Berlin", "-radius", "0", "-date", "2022-07-16"}
+
+	// Dropping the privileges would also affect the following tests.
+	noHarden := func(...string) {}
+
+	t.Run("gpx", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := exportCommand(append(args, "-format", "gpx"), &buf, noHarden); err != nil {
+			t.Fatal(err)
+		}
+
+		var doc gpx
+		if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.Version != "1.1" || len(doc.Waypoints) != 6 {
+			t.Fatalf("unexpected GPX %#v", doc)
+		}
+
+		wpt := doc.Waypoints[0]
+		if wpt.Name != "2022-07-16 52 13" || math.Abs(wpt.Lat-52.99178) > 0.00001 || math.Abs(wpt.Lon-13.20571) > 0.00001 {
+			t.Fatalf("unexpected waypoint %#v", wpt)
+		}
+		if wpt := doc.Waypoints[5]; wpt.Name != "2022-07-18 global" {
+			t.Fatalf("unexpected waypoint %#v", wpt)
+		}
+	})
+
+	t.Run("kml", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := exportCommand(append(args, "-format", "kml", "-globalhash=false"), &buf, noHarden); err != nil {
+			t.Fatal(err)
+		}
+
+		var doc kml
+		if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(doc.Placemarks) != 3 {
+			t.Fatalf("unexpected KML %#v", doc)
+		}
+
+		placemark := doc.Placemarks[0]
+		var lat, lon float64
+		if _, err := fmt.Sscanf(placemark.Coordinates, "%g,%g", &lon, &lat); err != nil {
+			t.Fatal(err)
+		}
+		if placemark.Name != "2022-07-16 52 13" || math.Abs(lat-52.99178) > 0.00001 || math.Abs(lon-13.20571) > 0.00001 {
+			t.Fatalf("unexpected placemark %#v", placemark)
+		}
+		if !strings.Contains(placemark.Description, "DJIA 30775.37 of 2022-07-15") {
+			t.Fatalf("unexpected description %q", placemark.Description)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		outFile := filepath.Join(t.TempDir(), "geohashes.gpx")
+
+		// The output file is already opened when dropping the privileges.
+		hardened := false
+		harden := func(...string) {
+			if _, err := os.Stat(outFile); err != nil {
+				t.Errorf("output file is not opened before hardening: %v", err)
+			}
+			hardened = true
+		}
+
+		if err := exportCommand(append(args, "-o", outFile), io.Discard, harden); err != nil {
+			t.Fatal(err)
+		} else if !hardened {
+			t.Fatal("privileges were not dropped")
+		}
+
+		data, err := os.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc gpx
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		} else if len(doc.Waypoints) != 6 {
+			t.Fatalf("unexpected GPX %#v", doc)
+		}
+	})
+
+	badArgs := [][]string{
+		{"-lon", "13.4"},
+		{"-lat", "52.5"},
+		{"-lat", "91", "-lon", "13.4"},
+		{"-lat", "52.5", "-lon", "13.4", "-format", "shp"},
+		{"-lat", "52.5", "-lon", "13.4", "-tz", "Europe/Marburg"},
+		{"-lat", "52.5", "-lon", "13.4", "-radius", "11"},
+		{"-lat", "52.5", "-lon", "13.4", "-date", "yesterday"},
+		{"-lat", "52.5", "-lon", "13.4", "-tz", "Europe/Berlin", "-date", "2022-07-20"},
+	}
+	for _, badArg := range badArgs {
+		t.Run(strings.Join(badArg, " "), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := exportCommand(badArg, &buf, noHarden); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestExportHandlerBadRequest(t *testing.T) {
+	for formatName := range exportFormats {
+		rec := httptest.NewRecorder()
+		exportHandler(formatName)(rec, httptest.NewRequest("GET", "/export/"+formatName+"?lat=52.5", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d instead of %d", http.StatusBadRequest, rec.Code)
+		}
+	}
+}
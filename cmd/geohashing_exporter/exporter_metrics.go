@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the exporter's self-instrumentation, served separately
+// from the geohash metrics.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics about the exporter itself, registered by registerExporterMetrics.
+var (
+	djiaFetchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "geohashing_exporter_djia_fetch_duration_seconds",
+			Help:    "Duration of fetching the DJIA from a single source by outcome.",
+			Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"source", "outcome"},
+	)
+	cacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "geohashing_exporter_cache_requests_total",
+			Help: "Lookups in the DJIA or the geohash cache by result, hit or miss.",
+		},
+		[]string{"cache", "result"},
+	)
+	computationDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "geohashing_exporter_computation_duration_seconds",
+			Help:    "Duration of calculating all geohashes for a single scrape.",
+			Buckets: []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+	)
+	w30NotYetAvailable = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "geohashing_exporter_w30_not_yet_available_total",
+			Help: "Locations which were not yet available due to the 30W rule.",
+		},
+	)
+	httpRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "geohashing_exporter_http_requests_total",
+			Help: "HTTP requests by handler and status code.",
+		},
+		[]string{"handler", "code"},
+	)
+)
+
+// cacheResult maps a cache lookup to the cacheRequests' result label.
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// registerExporterMetrics with the default Prometheus registry, next to the Go
+// and process collectors, and hook into the geohash package.
+func registerExporterMetrics() {
+	prometheus.MustRegister(
+		djiaFetchDuration,
+		cacheRequests,
+		computationDuration,
+		w30NotYetAvailable,
+		httpRequests,
+	)
+
+	geohash.SetHooks(exporterHooks())
+}
+
+// exporterHooks observe the geohash package for both the exporter metrics and
+// the spans of the otlpInstance, if enabled.
+func exporterHooks() geohash.Hooks {
+	return geohash.Hooks{
+		DjiaFetch: func(ctx context.Context, source string, duration time.Duration, err error) {
+			outcome := "success"
+			if err != nil {
+				outcome = "error"
+			}
+			djiaFetchDuration.WithLabelValues(source, outcome).Observe(duration.Seconds())
+			spanFromContext(ctx).setAttribute("djia.source", source)
+		},
+		DjiaCache: func(ctx context.Context, hit bool) {
+			cacheRequests.WithLabelValues("djia", cacheResult(hit)).Inc()
+			spanFromContext(ctx).setAttribute("cache.hit", hit)
+		},
+		Trace: func(ctx context.Context, operation string) (context.Context, func(error)) {
+			ctx, span := startSpan(ctx, operation, otlpSpanKindInternal)
+			return ctx, span.finish
+		},
+	}
+}
+
+// instrumentHandler counts the HTTP requests of a handler by status code and
+// traces them, if enabled.
+func instrumentHandler(name string, handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(
+		httpRequests.MustCurryWith(prometheus.Labels{"handler": name}),
+		traceHandler(name, handler))
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the Atom feed, listing newly available geohashes and
+// globalhashes of a named location for feed readers.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// atomLink is an Atom link element.
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+// atomEntry is an Atom entry element.
+type atomEntry struct {
+	Id      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// atomFeed is an Atom feed document's root.
+//
+// https://datatracker.ietf.org/doc/html/rfc4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// djiaPublished is the time when the hash's DJIA was published, i.e., the NYSE
+// opening of its DJIA date. This is when the hash became available.
+func djiaPublished(hash geohash.Hash) time.Time {
+	year, month, day := hash.DjiaDate.Date()
+	return time.Date(year, month, day, 9, 30, 0, 0, geohash.NyseTz())
+}
+
+// feedId identifies the feed of the named location, independent of the URL it
+// was requested by, e.g., behind a reverse proxy.
+func feedId(name string) string {
+	return "urn:geohashing-exporter:feed:" + url.PathEscape(name)
+}
+
+// writeFeed writes an Atom feed with an entry for each hash of the results.
+// Geohashes beyond the target's maxDistance are omitted, while the globalhash
+// is always listed.
+//
+// The feed's id is its feedId and each entry's id is its expedition's page on
+// geohashing.site, both being stable between requests. Entries are updated at
+// their djiaPublished time and listed newest first.
+func writeFeed(w io.Writer, name, selfLink string, t target, results []hashResult) error {
+	type entry struct {
+		atomEntry
+		date      time.Time
+		published time.Time
+	}
+	var entries []entry
+
+	for _, result := range results {
+		for _, hash := range result.hashes {
+			dist := distance(t.lat, t.lon, hash.Lat, hash.Lon)
+			if result.neighbour != nil && t.maxDistance > 0 && dist > t.maxDistance {
+				continue
+			}
+
+			expedition := expeditionName(hash.Date, result.neighbour)
+			pageUrl := "https://geohashing.site/geohashing/" + strings.ReplaceAll(expedition, " ", "_")
+			published := djiaPublished(hash)
+
+			entries = append(entries, entry{
+				atomEntry: atomEntry{
+					Id:      pageUrl,
+					Title:   fmt.Sprintf("%s (%.1f km)", expedition, dist/1000),
+					Updated: published.UTC().Format(time.RFC3339),
+					Link:    atomLink{Href: pageUrl},
+					Summary: fmt.Sprintf("%s at %f, %f, %.1f km from %s",
+						hashDescription(hash, result.neighbour), hash.Lat, hash.Lon, dist/1000, name),
+				},
+				date:      hash.Date,
+				published: published,
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].published.Equal(entries[j].published) {
+			return entries[i].published.After(entries[j].published)
+		}
+		return entries[i].date.After(entries[j].date)
+	})
+
+	feed := atomFeed{
+		Id:      feedId(name),
+		Title:   "Geohashes near " + name,
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+		Author:  "geohashing_exporter",
+		Link:    atomLink{Href: selfLink, Rel: "self"},
+		Entries: make([]atomEntry, 0, len(entries)),
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Updated
+	}
+	for _, e := range entries {
+		feed.Entries = append(feed.Entries, e.atomEntry)
+	}
+
+	return writeXml(w, feed)
+}
+
+// feedHandler creates a HTTP handler function, serving the Atom feed of the
+// named location from the config, given by the `target` GET parameter.
+func feedHandler(conf *config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		if name == "" {
+			http.Error(w, "`target` GET parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		t, ok := conf.targets[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		center, _ := graticuleFromPoint(t.lat, t.lon)
+		results := computeHashes(neighbourhood(center, t.radius), t.globalhash, time.Now().In(t.tz), ctx)
+
+		// The self link only contains the known parameter.
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		selfLink := fmt.Sprintf("%s://%s%s?target=%s", scheme, r.Host, r.URL.Path, url.QueryEscape(name))
+
+		var buf bytes.Buffer
+		err := writeFeed(&buf, name, selfLink, t, results)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot create feed: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		_, _ = buf.WriteTo(w)
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFeed(t *testing.T) {
+	setupTestProvider(t)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	target := target{lat: 52.516272, lon: 13.377722, tz: tz, radius: 0, globalhash: true, maxDistance: 52000}
+	center, _ := graticuleFromPoint(target.lat, target.lon)
+	date := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+	results := computeHashes(neighbourhood(center, target.radius), target.globalhash, date, context.Background())
+
+	selfLink := "http://localhost:9426/feed.atom?target=home"
+
+	var first, second bytes.Buffer
+	for _, buf := range []*bytes.Buffer{&first, &second} {
+		if err := writeFeed(buf, "home", selfLink, target, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.String() != second.String() {
+		t.Fatalf("feed is not stable:\n%s\n%s", first.String(), second.String())
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(first.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Id != "urn:geohashing-exporter:feed:home" || feed.Link.Href != selfLink || feed.Title != "Geohashes near home" || feed.Updated != "2022-07-15T13:30:00Z" {
+		t.Fatalf("unexpected feed %#v", feed)
+	}
+
+	// The center's hash on 2022-07-16 is more than 52 km away, while the far
+	// away globalhashes are always listed.
+	expectedIds := []string{
+		"https://geohashing.site/geohashing/2022-07-18_52_13",
+		"https://geohashing.site/geohashing/2022-07-18_global",
+		"https://geohashing.site/geohashing/2022-07-17_52_13",
+		"https://geohashing.site/geohashing/2022-07-17_global",
+		"https://geohashing.site/geohashing/2022-07-16_global",
+	}
+	if len(feed.Entries) != len(expectedIds) {
+		t.Fatalf("expected %d entries instead of %d:\n%s", len(expectedIds), len(feed.Entries), first.String())
+	}
+	for i, entry := range feed.Entries {
+		if entry.Id != expectedIds[i] || entry.Link.Href != expectedIds[i] {
+			t.Fatalf("expected entry %d to be %q instead of %q", i, expectedIds[i], entry.Id)
+		}
+		if entry.Updated != "2022-07-15T13:30:00Z" {
+			t.Fatalf("unexpected updated %q", entry.Updated)
+		}
+	}
+	if summary := feed.Entries[2].Summary; !strings.HasPrefix(summary, "Geohash of graticule 52,13 for 2022-07-17") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+}
+
+func TestFeedHandlerBadRequest(t *testing.T) {
+	handler := feedHandler(testConfig())
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/feed.atom", http.StatusBadRequest},
+		{"/feed.atom?target=office", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", test.url, nil))
+		if rec.Code != test.status {
+			t.Fatalf("expected status %d instead of %d for %s", test.status, rec.Code, test.url)
+		}
+	}
+}
+
+func TestFeedHandlerId(t *testing.T) {
+	setupTestProvider(t)
+
+	handler := feedHandler(testConfig())
+
+	// The same feed is identified equally, independent of its URL.
+	urls := []string{
+		"http://localhost:9426/feed.atom?target=home",
+		"http://localhost:9426/feed.atom?utm_source=reader&target=home",
+		"https://geohashing.example.org/feed.atom?target=home&foo=bar",
+	}
+	for _, url := range urls {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 instead of %d for %s", rec.Code, url)
+		}
+
+		var feed atomFeed
+		if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err)
+		}
+		if feed.Id != "urn:geohashing-exporter:feed:home" {
+			t.Fatalf("unexpected feed id %q for %s", feed.Id, url)
+		}
+		if !strings.HasSuffix(feed.Link.Href, "/feed.atom?target=home") {
+			t.Fatalf("unexpected self link %q for %s", feed.Link.Href, url)
+		}
+	}
+}
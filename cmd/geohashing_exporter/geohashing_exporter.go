@@ -10,156 +10,272 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
-	"github.com/oxzi/geohashing_exporter/geohash"
-
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
+// maxRadius limits the radius GET parameter. Each additional ring grows the
+// amount of queried graticules quadratically.
+const maxRadius = 10
+
 // metricsHandlerParseParams fetches the required GET parameters lat, lon, and
-// tz for the metricsHandler HTTP handler.
-func metricsHandlerParseParams(r *http.Request) (lat, lon int, tz string, err error) {
+// tz as well as the optional radius for the metricsHandler HTTP handler.
+//
+// The lat and lon parameters might either be a graticule, e.g., 50 and 8, or a
+// precise position, e.g., 50.810222 and 8.767017. The graticule containing this
+// position will be used, including the -0 graticules for negative positions.
+func metricsHandlerParseParams(r *http.Request) (t target, err error) {
 	latLonParams := []struct {
 		key   string
-		field *int
+		field *float64
 	}{
-		{"lat", &lat},
-		{"lon", &lon},
+		{"lat", &t.lat},
+		{"lon", &t.lon},
 	}
 	for _, param := range latLonParams {
-		*param.field, err = strconv.Atoi(r.URL.Query().Get(param.key))
+		*param.field, err = strconv.ParseFloat(r.URL.Query().Get(param.key), 64)
 		if err != nil {
-			err = fmt.Errorf("cannot parse `%s` GET parameter as an integer: %v", param.key, err)
+			err = fmt.Errorf("cannot parse `%s` GET parameter as a number: %v", param.key, err)
 			return
 		}
 	}
 
-	tz = r.URL.Query().Get("tz")
-	if tz == "" {
-		err = fmt.Errorf("`tz` GET parameter is missing")
+	_, err = graticuleFromPoint(t.lat, t.lon)
+	if err != nil {
 		return
 	}
 
-	return
-}
-
-// metricsHandlerGauges creates and populates the labeled Prometheus gauges for
-// the latitude and longitude to be returned in the metricsHandler HTTP handler.
-func metricsHandlerGauges(lat, lon int, tz string, ctx context.Context) (latGauge, lonGauge *prometheus.GaugeVec, err error) {
-	labels := []string{
-		// location describes which geohash is meant, as both the neighboring
-		// coordinates and the globalhash is also queried. One of:
-		// "nw", "n", "ne", "w", "center", "e", "sw", "s", "se", "global"
-		"location",
-		// day_offset says how many days the geohash lays in the future.
-		"day_offset",
-	}
-
-	latGauge = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "geohashing_lat",
-			Help: "Latitude of the geohash.",
-		},
-		labels,
-	)
-	lonGauge = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "geohashing_lon",
-			Help: "Longitude of the geohash.",
-		},
-		labels,
-	)
-
-	loc, err := time.LoadLocation(tz)
+	tzName := r.URL.Query().Get("tz")
+	if tzName == "" {
+		err = fmt.Errorf("`tz` GET parameter is missing")
+		return
+	}
+	t.tz, err = time.LoadLocation(tzName)
 	if err != nil {
+		err = fmt.Errorf("cannot load `tz` GET parameter as a time zone: %v", err)
 		return
 	}
-	localTime := time.Now().In(loc)
 
-	geoLocs := []struct {
-		name string
-		lat  int
-		lon  int
-	}{
-		{"nw", lat + 1, lon - 1},
-		{"n", lat + 1, lon},
-		{"ne", lat + 1, lon + 1},
-		{"w", lat, lon - 1},
-		{"center", lat, lon},
-		{"e", lat, lon + 1},
-		{"sw", lat - 1, lon - 1},
-		{"s", lat - 1, lon},
-		{"se", lat - 1, lon + 1},
-	}
-	for _, geoLoc := range geoLocs {
-		locs, locsErr := geohash.GetGeoHashProvider().GeoNext(geoLoc.lat, geoLoc.lon, localTime, ctx)
-		if locsErr != nil {
-			err = locsErr
+	t.radius = 1
+	if radiusParam := r.URL.Query().Get("radius"); radiusParam != "" {
+		t.radius, err = strconv.Atoi(radiusParam)
+		if err != nil {
+			err = fmt.Errorf("cannot parse `radius` GET parameter as an integer: %v", err)
+			return
+		} else if t.radius < 0 || t.radius > maxRadius {
+			err = fmt.Errorf("`radius` GET parameter must be between 0 and %d", maxRadius)
 			return
-		}
-
-		for i, loc := range locs {
-			label := prometheus.Labels{"location": geoLoc.name, "day_offset": fmt.Sprintf("%d", i)}
-			latGauge.With(label).Set(loc[0])
-			lonGauge.With(label).Set(loc[1])
 		}
 	}
 
-	globalLocs, err := geohash.GetGeoHashProvider().GlobalNext(localTime, ctx)
-	if err != nil {
-		return
+	t.globalhash = true
+	return
+}
+
+// scrapeTimeout for a request, based on Prometheus' scrape timeout header with
+// a small margin, capped at one minute. Otherwise, a default timeout of ten
+// seconds is used.
+func scrapeTimeout(r *http.Request) time.Duration {
+	const margin = 500 * time.Millisecond
+	const maxTimeoutSec = 60
+
+	timeoutSec, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || math.IsNaN(timeoutSec) || math.IsInf(timeoutSec, 0) || timeoutSec <= 0 {
+		return 10 * time.Second
+	} else if timeoutSec > maxTimeoutSec {
+		timeoutSec = maxTimeoutSec
 	}
-	for i, loc := range globalLocs {
-		label := prometheus.Labels{"location": "global", "day_offset": fmt.Sprintf("%d", i)}
-		latGauge.With(label).Set(loc[0])
-		lonGauge.With(label).Set(loc[1])
+
+	timeout := time.Duration(timeoutSec * float64(time.Second))
+	if timeout > 2*margin {
+		timeout -= margin
 	}
+	return timeout
+}
 
-	return
+// serveTarget responds with the metrics of a geohashCollector for the target.
+func serveTarget(t target, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r))
+	defer cancel()
+
+	// The request's context is passed to the collector by gathering directly,
+	// instead of registering it for each request.
+	collector := newGeohashCollector(t)
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return collector.gather(ctx)
+	})
+
+	promHandler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	promHandler.ServeHTTP(w, r)
 }
 
 // metricsHandler is a HTTP handler function for a Prometheus exporter, listing
 // the next geohashes coordinates in the requested coordinate window, the
-// neighboring ones and for the globalhash.
+// neighboring ones within the radius and for the globalhash.
+//
+// The metrics are created by a geohashCollector for the requested parameters.
 func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	lat, lon, tz, err := metricsHandlerParseParams(r)
+	t, err := metricsHandlerParseParams(r)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
+	serveTarget(t, w, r)
+}
 
-	latGauge, lonGauge, err := metricsHandlerGauges(lat, lon, tz, ctx)
-	if err != nil && !errors.Is(err, geohash.ErrW30NotYetAvailable) {
-		errMsg := fmt.Sprintf("cannot create gauges: %v", err)
-		log.Printf("Requesting %d,%d at %s failed: %s", lat, lon, tz, errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		return
-	}
+// probeHandler creates a HTTP handler function for a multi-target exporter,
+// resolving the `target` GET parameter as a named location from the config.
+// Besides this, it behaves like metricsHandler.
+func probeHandler(conf *config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		if name == "" {
+			http.Error(w, "`target` GET parameter is missing", http.StatusBadRequest)
+			return
+		}
 
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(latGauge)
-	registry.MustRegister(lonGauge)
+		t, ok := conf.targets[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
 
-	promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	promHandler.ServeHTTP(w, r)
+		serveTarget(t, w, r)
+	}
+}
+
+// subcommands of the geohashing_exporter, selected by the first argument and
+// called with the remaining arguments. Without a subcommand, the exporter runs.
+var subcommands = map[string]func(args []string) error{
+	"export":       func(args []string) error { return exportCommand(args, os.Stdout, toLeastPrivilege) },
+	"push":         pushCommand,
+	"remote-write": remoteWriteCommand,
 }
 
 func main() {
-	toLeastPrivilege()
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			err := subcommand(os.Args[2:])
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			} else if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
 
 	listenAddr := flag.String("listen", ":9426", "Listen address to be bound to")
+	configFile := flag.String("config", "", "YAML configuration file with named locations")
+	prefetch := flag.Bool("prefetch", false, "Poll for each new DJIA in the background after the NYSE opening")
+	web := flag.Bool("web", false, "Serve the web UI on "+webPrefix)
 	flag.Parse()
 
+	// The configuration must be read before dropping privileges.
+	conf := emptyConfig()
+	if *configFile != "" {
+		var err error
+		conf, err = loadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Loaded %d locations from %s", len(conf.targets), *configFile)
+	}
+
+	// The digest's state file must exist to be writable after dropping
+	// privileges.
+	var rwFiles []string
+	if conf.Digest != nil && conf.Digest.StateFile != "" {
+		err := touchStateFile(conf.Digest.StateFile)
+		if err != nil {
+			log.Fatalf("Cannot create the digest's state file: %v", err)
+		}
+		rwFiles = append(rwFiles, conf.Digest.StateFile)
+	}
+
+	toLeastPrivilege(rwFiles...)
+	registerExporterMetrics()
+
+	if conf.Otlp != nil {
+		otlpInstance = newOtlpExporter(conf)
+
+		log.Printf("Exporting OTLP metrics and traces to %s", conf.Otlp.Endpoint)
+		go otlpInstance.run(context.Background())
+	}
+
+	api := &apiServer{conf: conf}
+	var p *prefetcher
+	if *prefetch {
+		p = newPrefetcher(geoHashProvider)
+
+		api.events = newHashEvents()
+		p.addListener(api.events.notify)
+	}
+
+	if conf.Alerts != nil {
+		engine := newAlertEngine(conf)
+		if p != nil {
+			p.addListener(engine.notify)
+		}
+
+		log.Printf("Evaluating %d alert rules every %v", len(conf.Alerts.Rules), conf.Alerts.Interval)
+		go engine.run(context.Background())
+	}
+
+	if conf.Mqtt != nil {
+		pub := newMqttPublisher(conf)
+		if p != nil {
+			p.addListener(pub.notify)
+		}
+
+		log.Printf("Publishing to MQTT broker %s", conf.Mqtt.Addr)
+		go pub.run(context.Background())
+	}
+
+	if conf.Matrix != nil {
+		bot := newMatrixBot(conf)
+		if p != nil {
+			p.addListener(bot.notify)
+		} else {
+			log.Print("The Matrix bot only posts new hashes with -prefetch")
+		}
+
+		log.Printf("Starting Matrix bot for %d rooms on %s", len(conf.Matrix.Rooms), conf.Matrix.Homeserver)
+		go bot.run(context.Background())
+	}
+
+	if conf.Digest != nil {
+		go newDigestSender(conf).run(context.Background())
+	}
+
+	if p != nil {
+		go p.run(context.Background())
+	}
+
 	log.Printf("Starting geohashing_exporter on %s", *listenAddr)
 
-	http.HandleFunc("/metrics", metricsHandler)
+	http.Handle("/metrics", instrumentHandler("/metrics", http.HandlerFunc(metricsHandler)))
+	http.Handle("/probe", instrumentHandler("/probe", probeHandler(conf)))
+	http.Handle("/metrics/exporter", promhttp.Handler())
+	http.Handle("/geojson", instrumentHandler("/geojson", http.HandlerFunc(geojsonHandler)))
+	http.Handle("/calendar.ics", instrumentHandler("/calendar.ics", calendarHandler(conf)))
+	http.Handle("/feed.atom", instrumentHandler("/feed.atom", feedHandler(conf)))
+	for formatName := range exportFormats {
+		pattern := "/export/" + formatName
+		http.Handle(pattern, instrumentHandler(pattern, exportHandler(formatName)))
+	}
+	api.register(http.DefaultServeMux)
+	if *web {
+		http.Handle(webPrefix, instrumentHandler(webPrefix, webHandler()))
+	}
 	err := http.ListenAndServe(*listenAddr, nil)
 	if err != nil {
 		log.Panic(err)
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		header  string
+		timeout time.Duration
+	}{
+		{"", 10 * time.Second},
+		{"invalid", 10 * time.Second},
+		{"-1", 10 * time.Second},
+		{"10", 9500 * time.Millisecond},
+		{"2.5", 2 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"NaN", 10 * time.Second},
+		{"Inf", 10 * time.Second},
+		{"-Inf", 10 * time.Second},
+		{"1e12", 59500 * time.Millisecond},
+		{"1e400", 10 * time.Second},
+		{"60", 59500 * time.Millisecond},
+		{"120", 59500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if test.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", test.header)
+			}
+
+			if timeout := scrapeTimeout(r); timeout != test.timeout {
+				t.Fatalf("expected %v instead of %v", test.timeout, timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the GeoJSON endpoint, allowing geohashes to be displayed
+// on maps, e.g., Grafana's Geomap panel or uMap.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// geojsonGeometry is a GeoJSON Geometry object. Note that GeoJSON positions are
+// ordered as longitude, latitude.
+//
+// https://datatracker.ietf.org/doc/html/rfc7946#section-3.1
+type geojsonGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// geojsonFeature is a GeoJSON Feature object.
+type geojsonFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geojsonGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geojsonFeatureCollection is a GeoJSON FeatureCollection object.
+type geojsonFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geojsonFeature `json:"features"`
+}
+
+// geojsonPoint creates a Point Feature for a hash of the hashResult.
+func geojsonPoint(t target, result hashResult, dayOffset int) geojsonFeature {
+	hash := result.hashes[dayOffset]
+
+	properties := map[string]interface{}{
+		"kind":            "geohash",
+		"location":        result.name(),
+		"date":            hash.Date.Format("2006-01-02"),
+		"day_offset":      dayOffset,
+		"distance_meters": distance(t.lat, t.lon, hash.Lat, hash.Lon),
+		"stale":           result.stale,
+	}
+	if n := result.neighbour; n != nil {
+		properties["graticule"] = n.graticule.String()
+	}
+
+	return geojsonFeature{
+		Type: "Feature",
+		Geometry: geojsonGeometry{
+			Type:        "Point",
+			Coordinates: [2]float64{hash.Lon, hash.Lat},
+		},
+		Properties: properties,
+	}
+}
+
+// geojsonBoundary creates a Polygon Feature for the neighbour's graticule.
+func geojsonBoundary(n *neighbour) geojsonFeature {
+	minLat, minLon, maxLat, maxLon := n.graticule.bounds()
+
+	// A linear ring is closed and the exterior ring is counterclockwise.
+	ring := [][2]float64{
+		{minLon, minLat},
+		{maxLon, minLat},
+		{maxLon, maxLat},
+		{minLon, maxLat},
+		{minLon, minLat},
+	}
+
+	return geojsonFeature{
+		Type: "Feature",
+		Geometry: geojsonGeometry{
+			Type:        "Polygon",
+			Coordinates: [][][2]float64{ring},
+		},
+		Properties: map[string]interface{}{
+			"kind":      "graticule",
+			"location":  n.name,
+			"graticule": n.graticule.String(),
+		},
+	}
+}
+
+// geojsonCollection creates a FeatureCollection with a Point for each hash of
+// the results. If boundaries is set, each neighbour's graticule is added as a
+// Polygon, even if no hash is available.
+func geojsonCollection(t target, results []hashResult, boundaries bool) geojsonFeatureCollection {
+	collection := geojsonFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []geojsonFeature{},
+	}
+
+	for _, result := range results {
+		if boundaries && result.neighbour != nil {
+			collection.Features = append(collection.Features, geojsonBoundary(result.neighbour))
+		}
+
+		for i := range result.hashes {
+			collection.Features = append(collection.Features, geojsonPoint(t, result, i))
+		}
+	}
+	return collection
+}
+
+// geojsonHandler is a HTTP handler function, serving the next geohashes as a
+// GeoJSON FeatureCollection for the same parameters as the metricsHandler.
+//
+// The optional `boundaries` parameter adds each graticule as a Polygon.
+// Locations without any available geohash are omitted, as for the metrics.
+func geojsonHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := metricsHandlerParseParams(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		return
+	}
+
+	boundaries := false
+	if boundariesParam := r.URL.Query().Get("boundaries"); boundariesParam != "" {
+		boundaries, err = strconv.ParseBool(boundariesParam)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot parse `boundaries` GET parameter as a boolean: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), scrapeTimeout(r))
+	defer cancel()
+
+	// The center was already validated while parsing the parameters.
+	center, _ := graticuleFromPoint(t.lat, t.lon)
+	results := computeHashes(neighbourhood(center, t.radius), t.globalhash, time.Now().In(t.tz), ctx)
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	err = json.NewEncoder(w).Encode(geojsonCollection(t, results, boundaries))
+	if err != nil {
+		log.Printf("Cannot write GeoJSON response: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGeojsonCollection(t *testing.T) {
+	setupTestProvider(t)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	target := target{lat: 52.516272, lon: 13.377722, tz: tz, radius: 0, globalhash: true}
+	center, _ := graticuleFromPoint(target.lat, target.lon)
+	date := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+	results := computeHashes(neighbourhood(center, target.radius), target.globalhash, date, context.Background())
+
+	tests := []struct {
+		boundaries bool
+		features   int
+	}{
+		{false, 6},
+		{true, 7},
+	}
+
+	for _, test := range tests {
+		collection := geojsonCollection(target, results, test.boundaries)
+		if len(collection.Features) != test.features {
+			t.Fatalf("expected %d features instead of %d", test.features, len(collection.Features))
+		}
+
+		// Encode and decode the collection to check its JSON representation.
+		data, err := json.Marshal(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded struct {
+			Type     string `json:"type"`
+			Features []struct {
+				Geometry struct {
+					Type        string          `json:"type"`
+					Coordinates json.RawMessage `json:"coordinates"`
+				} `json:"geometry"`
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"features"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Type != "FeatureCollection" {
+			t.Fatalf("unexpected type %q", decoded.Type)
+		}
+
+		features := decoded.Features
+		if test.boundaries {
+			var ring [][][2]float64
+			if err := json.Unmarshal(features[0].Geometry.Coordinates, &ring); err != nil {
+				t.Fatal(err)
+			}
+			if features[0].Geometry.Type != "Polygon" || len(ring) != 1 || len(ring[0]) != 5 ||
+				ring[0][0] != [2]float64{13, 52} || ring[0][2] != [2]float64{14, 53} {
+				t.Fatalf("unexpected boundary %v", ring)
+			}
+			features = features[1:]
+		}
+
+		var point [2]float64
+		if err := json.Unmarshal(features[0].Geometry.Coordinates, &point); err != nil {
+			t.Fatal(err)
+		}
+		if features[0].Geometry.Type != "Point" || math.Abs(point[0]-13.20571) > 0.00001 || math.Abs(point[1]-52.99178) > 0.00001 {
+			t.Fatalf("unexpected point %v", point)
+		}
+
+		props := features[0].Properties
+		if props["location"] != "center" || props["graticule"] != "52,13" || props["date"] != "2022-07-16" || props["day_offset"] != 0.0 {
+			t.Fatalf("unexpected properties %v", props)
+		}
+		if _, ok := props["distance_meters"]; !ok {
+			t.Fatalf("distance_meters is missing in %v", props)
+		}
+
+		if props := features[3].Properties; props["location"] != "global" {
+			t.Fatalf("unexpected globalhash properties %v", props)
+		} else if _, ok := props["graticule"]; ok {
+			t.Fatalf("unexpected graticule for globalhash in %v", props)
+		}
+	}
+}
+
+func TestGeojsonHandlerBadRequest(t *testing.T) {
+	for _, url := range []string{
+		"/geojson?lat=52.5&tz=Europe/Berlin",
+		"/geojson?lat=52.5&lon=13.4&tz=Europe/Berlin&boundaries=maybe",
+	} {
+		rec := httptest.NewRecorder()
+		geojsonHandler(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d instead of %d for %s", http.StatusBadRequest, rec.Code, url)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the graticule logic, mapping precise coordinates to their
+// coordinate window and finding neighboring windows.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// graticule is a coordinate window of one by one degree, e.g., 50,8.
+//
+// As there are both a 0 and a -0 graticule on each axis, the graticules are
+// stored as an index. A non-negative index i is the graticule i, while a
+// negative index i represents the graticule -(|i|-1). Thus, -1 is -0, -2 is -1
+// and so on. This allows neighbours to be found by simple arithmetic.
+type graticule struct {
+	latIdx, lonIdx int
+}
+
+// Valid graticule indices, [minIdx, maxIdx].
+const (
+	minLatIdx, maxLatIdx = -90, 89
+	minLonIdx, maxLonIdx = -180, 179
+)
+
+// graticuleIdx converts a coordinate into its graticule index.
+func graticuleIdx(coord float64) int {
+	if math.Signbit(coord) {
+		return -int(math.Trunc(-coord)) - 1
+	}
+	return int(math.Trunc(coord))
+}
+
+// graticuleFromPoint returns the graticule containing the given coordinates.
+//
+// An error is returned for coordinates outside of [-90, 90] and [-180, 180].
+func graticuleFromPoint(lat, lon float64) (g graticule, err error) {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		err = fmt.Errorf("latitude %v is not within [-90, 90]", lat)
+		return
+	} else if math.IsNaN(lon) || lon < -180 || lon > 180 {
+		err = fmt.Errorf("longitude %v is not within [-180, 180]", lon)
+		return
+	}
+
+	// The poles and the antimeridian are part of the adjacent graticule.
+	clamp := func(idx, minIdx, maxIdx int) int {
+		if idx < minIdx {
+			return minIdx
+		} else if idx > maxIdx {
+			return maxIdx
+		}
+		return idx
+	}
+	g.latIdx = clamp(graticuleIdx(lat), minLatIdx, maxLatIdx)
+	g.lonIdx = clamp(graticuleIdx(lon), minLonIdx, maxLonIdx)
+	return
+}
+
+// graticuleArea converts an index into the integer area, as used by the geohash
+// package, and whether this is a negative graticule, required to detect -0.
+func graticuleArea(idx int) (area int, neg bool) {
+	if idx < 0 {
+		return idx + 1, true
+	}
+	return idx, false
+}
+
+// graticuleAreaString formats a graticule index, including a -0.
+func graticuleAreaString(idx int) string {
+	area, neg := graticuleArea(idx)
+	if neg && area == 0 {
+		return "-0"
+	}
+	return strconv.Itoa(area)
+}
+
+// String representation of a graticule, e.g., "50,8" or "-0,-0".
+func (g graticule) String() string {
+	return graticuleAreaString(g.latIdx) + "," + graticuleAreaString(g.lonIdx)
+}
+
+// offset returns the graticule with the given offset to this one.
+//
+// Longitudes are wrapped around the antimeridian. There is no graticule beyond
+// the poles, which results in ok being false.
+func (g graticule) offset(latOffset, lonOffset int) (other graticule, ok bool) {
+	other.latIdx = g.latIdx + latOffset
+	if other.latIdx < minLatIdx || other.latIdx > maxLatIdx {
+		return
+	}
+
+	lonSpan := maxLonIdx - minLonIdx + 1
+	other.lonIdx = ((g.lonIdx+lonOffset-minLonIdx)%lonSpan+lonSpan)%lonSpan + minLonIdx
+
+	ok = true
+	return
+}
+
+// bounds of this graticule as its south west and north east corners.
+//
+// Due to the index representation, the index is also the southern or western
+// bound, e.g., -1 for the graticule -0 spanning [-1, 0].
+func (g graticule) bounds() (minLat, minLon, maxLat, maxLon float64) {
+	minLat, minLon = float64(g.latIdx), float64(g.lonIdx)
+	maxLat, maxLon = minLat+1, minLon+1
+	return
+}
+
+// geoNext calculates the next geohashes of this graticule, as done by
+// geohash.GeoHashProvider.GeoNextHashes, but also supports -0 graticules.
+func (g graticule) geoNext(provider *geohash.GeoHashProvider, date time.Time, ctx context.Context) (hashes []geohash.Hash, err error) {
+	latArea, latNeg := graticuleArea(g.latIdx)
+	lonArea, lonNeg := graticuleArea(g.lonIdx)
+
+	hashes, err = provider.GeoNextHashes(latArea, lonArea, date, ctx)
+	if err != nil {
+		return
+	}
+
+	// The geohash package cannot distinguish between 0 and -0 and treats both as
+	// positive. Thus, the sign must be flipped for -0.
+	for i := range hashes {
+		if latNeg && latArea == 0 {
+			hashes[i].Lat = -hashes[i].Lat
+		}
+		if lonNeg && lonArea == 0 {
+			hashes[i].Lon = -hashes[i].Lon
+		}
+	}
+	return
+}
+
+// neighbour is a graticule relative to the requested one.
+type neighbour struct {
+	// name of this neighbour's location, e.g., "center", "nw", or "n2e".
+	name string
+	// latOffset and lonOffset to the requested graticule.
+	latOffset, lonOffset int
+	// graticule of this neighbour.
+	graticule graticule
+}
+
+// neighbourName creates a location name for the given offsets.
+//
+// The center is called "center". Otherwise, the name consists of a latitude
+// part, "n" or "s", followed by a longitude part, "e" or "w". Each part is
+// omitted for a zero offset and followed by its distance if it is greater than
+// one. Thus, the direct neighbours are named "nw", "n", …, "se" and the
+// graticule two rows north and one column east is called "n2e".
+func neighbourName(latOffset, lonOffset int) string {
+	if latOffset == 0 && lonOffset == 0 {
+		return "center"
+	}
+
+	parts := []struct {
+		offset   int
+		pos, neg string
+	}{
+		{latOffset, "n", "s"},
+		{lonOffset, "e", "w"},
+	}
+
+	name := ""
+	for _, part := range parts {
+		switch {
+		case part.offset == 0:
+			continue
+		case part.offset > 0:
+			name += part.pos
+		default:
+			name += part.neg
+		}
+
+		if dist := abs(part.offset); dist > 1 {
+			name += strconv.Itoa(dist)
+		}
+	}
+	return name
+}
+
+// abs of an integer.
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// neighbourhood of the graticule g with the given radius, resulting in up to
+// (2*radius+1)^2 neighbours. They are ordered from the north west to the south
+// east, row by row. Rows beyond the poles are omitted.
+func neighbourhood(g graticule, radius int) (neighbours []neighbour) {
+	for latOffset := radius; latOffset >= -radius; latOffset-- {
+		for lonOffset := -radius; lonOffset <= radius; lonOffset++ {
+			other, ok := g.offset(latOffset, lonOffset)
+			if !ok {
+				continue
+			}
+
+			neighbours = append(neighbours, neighbour{
+				name:      neighbourName(latOffset, lonOffset),
+				latOffset: latOffset,
+				lonOffset: lonOffset,
+				graticule: other,
+			})
+		}
+	}
+	return
+}
+
+// earthRadius in meters, as also used in contrib/prometheus/rule_gen.py.
+const earthRadius = 6367000.0
+
+// distance between two coordinates in meters, based on the Haversine formula.
+//
+// https://en.wikipedia.org/wiki/Haversine_formula
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	d2r := math.Pi / 180.0
+	square := func(x float64) float64 { return x * x }
+
+	dLat := (lat2 - lat1) * d2r
+	dLon := (lon2 - lon1) * d2r
+
+	a := square(math.Sin(dLat/2.0)) + math.Cos(lat1*d2r)*math.Cos(lat2*d2r)*square(math.Sin(dLon/2.0))
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return earthRadius * c
+}
@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestGraticuleFromPoint(t *testing.T) {
+	tests := []struct {
+		lat   float64
+		lon   float64
+		isErr bool
+		name  string
+	}{
+		{50, 8, false, "50,8"},
+		{50.810222, 8.767017, false, "50,8"},
+		{-33.856784, 151.215297, false, "-33,151"},
+		{40.689167, -74.044444, false, "40,-74"},
+		{0.5, -0.5, false, "0,-0"},
+		{-0.5, 0.5, false, "-0,0"},
+		{math.Copysign(0, -1), 0, false, "-0,0"},
+		{-1, -1, false, "-1,-1"},
+		{90, 180, false, "89,179"},
+		{-90, -180, false, "-89,-179"},
+		{90.1, 0, true, ""},
+		{0, -180.1, true, ""},
+		{math.NaN(), 0, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v,%v", test.lat, test.lon), func(t *testing.T) {
+			g, err := graticuleFromPoint(test.lat, test.lon)
+			if (err != nil) != test.isErr {
+				t.Fatalf("expected isErr = %t, err = %v", test.isErr, err)
+			} else if test.isErr {
+				return
+			}
+
+			if name := g.String(); name != test.name {
+				t.Fatalf("expected %q instead of %q", test.name, name)
+			}
+		})
+	}
+}
+
+func TestGraticuleOffset(t *testing.T) {
+	tests := []struct {
+		from      string
+		latOffset int
+		lonOffset int
+		ok        bool
+		to        string
+	}{
+		{"50,8", 1, -1, true, "51,7"},
+		{"0,0", -1, -1, true, "-0,-0"},
+		{"-0,-0", 1, 1, true, "0,0"},
+		{"-0,-0", -1, -1, true, "-1,-1"},
+		{"10,179", 0, 1, true, "10,-179"},
+		{"10,-179", 0, -1, true, "10,179"},
+		{"10,-179", 0, -3, true, "10,177"},
+		{"89,0", 1, 0, false, ""},
+		{"-88,0", -1, 0, true, "-89,0"},
+		{"-89,0", -1, 0, false, ""},
+	}
+
+	parse := func(s string) graticule {
+		var lat, lon float64
+		if _, err := fmt.Sscanf(s, "%g,%g", &lat, &lon); err != nil {
+			t.Fatal(err)
+		}
+		g, err := graticuleFromPoint(lat, lon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s+%d,%d", test.from, test.latOffset, test.lonOffset), func(t *testing.T) {
+			g, ok := parse(test.from).offset(test.latOffset, test.lonOffset)
+			if ok != test.ok {
+				t.Fatalf("expected ok = %t", test.ok)
+			} else if !ok {
+				return
+			}
+
+			if name := g.String(); name != test.to {
+				t.Fatalf("expected %q instead of %q", test.to, name)
+			}
+		})
+	}
+}
+
+func TestGraticuleBounds(t *testing.T) {
+	tests := []struct {
+		lat    float64
+		lon    float64
+		bounds [4]float64
+	}{
+		{50.81, 8.76, [4]float64{50, 8, 51, 9}},
+		{-0.5, -0.5, [4]float64{-1, -1, 0, 0}},
+		{-33.9, 151.2, [4]float64{-34, 151, -33, 152}},
+		{90, 180, [4]float64{89, 179, 90, 180}},
+		{-90, -180, [4]float64{-90, -180, -89, -179}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v,%v", test.lat, test.lon), func(t *testing.T) {
+			g, err := graticuleFromPoint(test.lat, test.lon)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			minLat, minLon, maxLat, maxLon := g.bounds()
+			if bounds := [4]float64{minLat, minLon, maxLat, maxLon}; bounds != test.bounds {
+				t.Fatalf("expected %v instead of %v", test.bounds, bounds)
+			}
+		})
+	}
+}
+
+func TestNeighbourName(t *testing.T) {
+	tests := []struct {
+		latOffset int
+		lonOffset int
+		name      string
+	}{
+		{0, 0, "center"},
+		{1, -1, "nw"},
+		{1, 0, "n"},
+		{1, 1, "ne"},
+		{0, -1, "w"},
+		{0, 1, "e"},
+		{-1, -1, "sw"},
+		{-1, 0, "s"},
+		{-1, 1, "se"},
+		{2, 1, "n2e"},
+		{-1, -3, "sw3"},
+		{0, 2, "e2"},
+		{-10, 10, "s10e10"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d,%d", test.latOffset, test.lonOffset), func(t *testing.T) {
+			if name := neighbourName(test.latOffset, test.lonOffset); name != test.name {
+				t.Fatalf("expected %q instead of %q", test.name, name)
+			}
+		})
+	}
+}
+
+func TestNeighbourhood(t *testing.T) {
+	for radius := 0; radius <= 3; radius++ {
+		t.Run(fmt.Sprintf("radius=%d", radius), func(t *testing.T) {
+			center := graticule{latIdx: 50, lonIdx: 8}
+			neighbours := neighbourhood(center, radius)
+			if l, expected := len(neighbours), (2*radius+1)*(2*radius+1); l != expected {
+				t.Fatalf("expected %d neighbours instead of %d", expected, l)
+			}
+
+			names := make(map[string]bool)
+			for _, n := range neighbours {
+				if names[n.name] {
+					t.Fatalf("duplicate name %q", n.name)
+				}
+				names[n.name] = true
+
+				if g, _ := center.offset(n.latOffset, n.lonOffset); g != n.graticule {
+					t.Fatalf("%q has graticule %v for offset %d,%d", n.name, n.graticule, n.latOffset, n.lonOffset)
+				}
+			}
+
+			if !names["center"] {
+				t.Fatal("center is missing")
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// Marburg castle to the Brandenburg Gate, roughly 370km.
+	d := distance(50.810222, 8.767017, 52.516272, 13.377722)
+	if d < 365000 || d > 375000 {
+		t.Fatalf("unexpected distance of %fm", d)
+	}
+
+	if d := distance(50, 8, 50, 8); d != 0 {
+		t.Fatalf("expected no distance instead of %fm", d)
+	}
+}
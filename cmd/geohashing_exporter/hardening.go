@@ -11,7 +11,8 @@ import (
 	"runtime"
 )
 
-// toLeastPrivilege drops privileges by some OS-specific method.
-func toLeastPrivilege() {
+// toLeastPrivilege drops privileges by some OS-specific method. The rwFiles
+// must stay readable and writable.
+func toLeastPrivilege(rwFiles ...string) {
 	log.Printf("Cannot reduce privileges on %s/%s", runtime.GOOS, runtime.GOARCH)
 }
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"crypto/x509"
 	"log"
 	"os"
 	"strings"
@@ -30,13 +31,23 @@ var platformZoneSources []string
 //
 // Thus, we access this not exported variable, filter for path validity as
 // go-landlock returns an error otherwise. I just want to have unveil(2)..
-func toLeastPrivilegeLandlock() {
+//
+// Additionally, the existing rwFiles stay readable and writable.
+func toLeastPrivilegeLandlock(rwFiles []string) {
 	_, err := llsys.LandlockGetABIVersion()
 	if err != nil {
 		log.Printf("Landlock is not supported.")
 		return
 	}
 
+	// Golang's crypto/x509 package loads the system's CA certificates lazily for
+	// the first TLS connection, e.g., to a webhook. As their paths vary between
+	// distributions, they are loaded now instead of being allowed.
+	_, err = x509.SystemCertPool()
+	if err != nil {
+		log.Printf("Cannot load the system's CA certificates: %v", err)
+	}
+
 	allowedZoneSourceDirs := []string{}
 	allowedZoneSourceFiles := []string{}
 	for _, zoneSource := range platformZoneSources {
@@ -63,6 +74,9 @@ func toLeastPrivilegeLandlock() {
 			"/etc/nsswitch.conf",
 			"/etc/resolv.conf",
 		),
+
+		// Files required by some features, e.g., the digest's state file
+		landlock.RWFiles(rwFiles...),
 	)
 	if err != nil {
 		log.Fatalf("Cannot apply Landlock filter: %v", err)
@@ -93,8 +107,9 @@ func toLeastPrivilegeSeccompBpf() {
 	}
 }
 
-// toLeastPrivilege is achieved on a Linux with Landlock and seccomp-bpf.
-func toLeastPrivilege() {
-	toLeastPrivilegeLandlock()
+// toLeastPrivilege is achieved on a Linux with Landlock and seccomp-bpf. The
+// rwFiles must exist and stay readable and writable.
+func toLeastPrivilege(rwFiles ...string) {
+	toLeastPrivilegeLandlock(rwFiles)
 	toLeastPrivilegeSeccompBpf()
 }
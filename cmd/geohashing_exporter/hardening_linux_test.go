@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestToLeastPrivilege verifies that the system's CA certificates and the
+// rwFiles are still available after dropping privileges. As this cannot be
+// undone, the test runs itself in a subprocess.
+func TestToLeastPrivilege(t *testing.T) {
+	rwFile := os.Getenv("GEOHASHING_EXPORTER_TEST_HARDENING")
+	if rwFile == "" {
+		rwFile = filepath.Join(t.TempDir(), "state")
+		if err := touchStateFile(rwFile); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestToLeastPrivilege$")
+		cmd.Env = append(os.Environ(), "GEOHASHING_EXPORTER_TEST_HARDENING="+rwFile)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("hardened subprocess failed: %v\n%s", err, out)
+		}
+		return
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	toLeastPrivilege(rwFile)
+
+	if err := os.WriteFile(rwFile, []byte("2022-07-16\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test server's certificate is unknown to the system's CA certificates,
+	// which must have been loaded nevertheless.
+	_, err := http.Get(server.URL)
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthorityErr) {
+		t.Fatalf("expected an unknown authority error instead of %v", err)
+	}
+}
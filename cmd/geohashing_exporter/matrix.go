@@ -0,0 +1,427 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the optional Matrix bot, posting new nearby geohashes to
+// rooms and answering commands, based on the Matrix client-server API.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// matrixEvent is a room event within a matrixSyncResponse.
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	EventId string `json:"event_id"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+// matrixSyncResponse is the relevant part of a /sync response.
+//
+// https://spec.matrix.org/v1.8/client-server-api/#get_matrixclientv3sync
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// matrixAnnouncedType of the bot's account data, remembering the latest DJIA
+// date whose new hashes were posted, e.g., across restarts.
+const matrixAnnouncedType = "org.geohashing_exporter.announced"
+
+// matrixAnnounced is the account data content of matrixAnnouncedType.
+type matrixAnnounced struct {
+	DjiaDate string `json:"djia_date"`
+}
+
+// errMatrixNotFound is returned for requests the homeserver responded to with
+// 404 Not Found, e.g., for unset account data.
+var errMatrixNotFound = errors.New("not found")
+
+// matrixBot posts new nearby geohashes to the configured rooms and answers
+// commands within those rooms.
+type matrixBot struct {
+	conf   *config
+	client *http.Client
+	// now is the current time, only to be altered for testing.
+	now func() time.Time
+	// syncTimeout for long polling the homeserver.
+	syncTimeout time.Duration
+
+	// txnCounter creates unique transaction ids together with txnPrefix.
+	txnCounter atomic.Int64
+	txnPrefix  string
+
+	// announceMu guards announced, the DJIA date of the latest posted hashes.
+	// It is loaded from the account data, if still empty.
+	announceMu sync.Mutex
+	announced  string
+}
+
+// newMatrixBot for the config, which must have matrix.
+func newMatrixBot(conf *config) *matrixBot {
+	return &matrixBot{
+		conf:        conf,
+		client:      &http.Client{Timeout: time.Minute},
+		now:         time.Now,
+		syncTimeout: 30 * time.Second,
+		txnPrefix:   strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// location of the bot, being its home for distances and its time zone.
+func (bot *matrixBot) location() target {
+	return bot.conf.targets[bot.conf.Matrix.Location]
+}
+
+// request the homeserver's client-server API and decode the JSON response.
+func (bot *matrixBot) request(method, path string, query url.Values, body, response interface{}, ctx context.Context) error {
+	u := strings.TrimSuffix(bot.conf.Matrix.Homeserver, "/") + "/_matrix/client/v3" + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+bot.conf.Matrix.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := bot.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("homeserver responded with %s: %w", resp.Status, errMatrixNotFound)
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("homeserver responded with %s", resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// sync with the homeserver since the given batch, long polling for timeout.
+func (bot *matrixBot) sync(since string, timeout time.Duration, ctx context.Context) (resp matrixSyncResponse, err error) {
+	query := url.Values{"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)}}
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	err = bot.request(http.MethodGet, "/sync", query, nil, &resp, ctx)
+	return
+}
+
+// send a notice to the room.
+func (bot *matrixBot) send(roomId, body string, ctx context.Context) error {
+	txnId := fmt.Sprintf("%s.%d", bot.txnPrefix, bot.txnCounter.Add(1))
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(roomId), url.PathEscape(txnId))
+
+	return bot.request(http.MethodPut, path, nil, map[string]string{
+		"msgtype": "m.notice",
+		"body":    body,
+	}, nil, ctx)
+}
+
+// announcedPath of the bot's account data for matrixAnnouncedType.
+func (bot *matrixBot) announcedPath() string {
+	return fmt.Sprintf("/user/%s/account_data/%s", url.PathEscape(bot.conf.Matrix.UserId), matrixAnnouncedType)
+}
+
+// loadAnnounced DJIA date from the account data, being empty if it is unset.
+func (bot *matrixBot) loadAnnounced(ctx context.Context) (djiaDate string, err error) {
+	var content matrixAnnounced
+	err = bot.request(http.MethodGet, bot.announcedPath(), nil, nil, &content, ctx)
+	if errors.Is(err, errMatrixNotFound) {
+		return "", nil
+	}
+	return content.DjiaDate, err
+}
+
+// storeAnnounced DJIA date in the account data.
+func (bot *matrixBot) storeAnnounced(djiaDate string, ctx context.Context) error {
+	return bot.request(http.MethodPut, bot.announcedPath(), nil, matrixAnnounced{DjiaDate: djiaDate}, nil, ctx)
+}
+
+// formatResults lists each hash of the results with its distance to the bot's
+// location. Hashes not accepted by the filter, if given, are omitted.
+func (bot *matrixBot) formatResults(results []hashResult, filter func(dist float64) bool) string {
+	t := bot.location()
+
+	var lines []string
+	for _, result := range results {
+		for _, hash := range result.hashes {
+			dist := distance(t.lat, t.lon, hash.Lat, hash.Lon)
+			if filter != nil && !filter(dist) {
+				continue
+			}
+
+			lines = append(lines, fmt.Sprintf("%s: %f, %f (%.1f km) %s",
+				expeditionName(hash.Date, result.neighbour), hash.Lat, hash.Lon, dist/1000, osmLink(hash.Lat, hash.Lon)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatErrors lists the errors of results without any hashes.
+func formatErrors(results []hashResult, ctx context.Context) string {
+	var lines []string
+	for _, result := range results {
+		if result.err != nil && len(result.hashes) == 0 {
+			lines = append(lines, fmt.Sprintf("%s is unavailable: %s", result.name(), errorReason(result.err, ctx)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matrixHelp lists the supported commands.
+const matrixHelp = `!hash LAT LON [YYYY-MM-DD] lists the geohashes of a graticule, e.g., !hash 50 8
+!global [YYYY-MM-DD] lists the globalhashes
+!near DISTANCE lists the geohashes and globalhashes within a distance, e.g., !near 30km
+!help shows this help`
+
+// command answers a message, if it is a known command.
+func (bot *matrixBot) command(body string, ctx context.Context) (reply string, ok bool) {
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return
+	}
+
+	t := bot.location()
+	date := bot.now().In(t.tz)
+
+	// parseDate of an optional argument.
+	parseDate := func(args []string) error {
+		if len(args) == 0 {
+			return nil
+		}
+		var err error
+		date, err = parseDay(args[0], t.tz, bot.now())
+		return err
+	}
+
+	var results []hashResult
+	var filter func(float64) bool
+
+	switch fields[0] {
+	case "!help":
+		return matrixHelp, true
+
+	case "!hash":
+		if len(fields) < 3 || len(fields) > 4 {
+			return "Usage: !hash LAT LON [YYYY-MM-DD]", true
+		}
+
+		var coords [2]float64
+		for i := range coords {
+			var err error
+			coords[i], err = strconv.ParseFloat(fields[1+i], 64)
+			if err != nil {
+				return fmt.Sprintf("Cannot parse the graticule: %v", err), true
+			}
+		}
+		g, err := graticuleFromPoint(coords[0], coords[1])
+		if err != nil {
+			return fmt.Sprintf("Invalid graticule: %v", err), true
+		}
+		if err := parseDate(fields[3:]); err != nil {
+			return fmt.Sprintf("Invalid date: %v", err), true
+		}
+
+		results = computeHashes([]neighbour{{name: "center", graticule: g}}, false, date, ctx)
+
+	case "!global":
+		if len(fields) > 2 {
+			return "Usage: !global [YYYY-MM-DD]", true
+		}
+		if err := parseDate(fields[1:]); err != nil {
+			return fmt.Sprintf("Invalid date: %v", err), true
+		}
+
+		results = computeHashes(nil, true, date, ctx)
+
+	case "!near":
+		if len(fields) != 2 {
+			return "Usage: !near DISTANCE, e.g., !near 30km", true
+		}
+
+		maxKm, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "km"), 64)
+		if err != nil || maxKm <= 0 {
+			return fmt.Sprintf("Invalid distance %q", fields[1]), true
+		}
+
+		center, _ := graticuleFromPoint(t.lat, t.lon)
+		results = computeHashes(neighbourhood(center, t.radius), t.globalhash, date, ctx)
+		filter = func(dist float64) bool { return dist <= maxKm*1000 }
+
+	default:
+		return
+	}
+
+	reply = bot.formatResults(results, filter)
+	if reply == "" {
+		reply = "No hashes were found."
+	}
+	if errs := formatErrors(results, ctx); errs != "" {
+		reply += "\n" + errs
+	}
+	return reply, true
+}
+
+// handleSync answers the commands within the sync's events in the configured
+// rooms, ignoring the bot's own messages.
+func (bot *matrixBot) handleSync(resp matrixSyncResponse, ctx context.Context) {
+	for _, roomId := range bot.conf.Matrix.Rooms {
+		for _, event := range resp.Rooms.Join[roomId].Timeline.Events {
+			if event.Type != "m.room.message" || event.Sender == bot.conf.Matrix.UserId || event.Content.MsgType != "m.text" {
+				continue
+			}
+
+			cmdCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			reply, ok := bot.command(event.Content.Body, cmdCtx)
+			if ok {
+				err := bot.send(roomId, reply, cmdCtx)
+				if err != nil {
+					log.Printf("Cannot answer %s in %s: %v", event.EventId, roomId, err)
+				}
+			}
+			cancel()
+		}
+	}
+}
+
+// notify the bot about a new DJIA, implementing djiaListener. New hashes based
+// on this DJIA are announced in the background.
+func (bot *matrixBot) notify(date time.Time, _ float64) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		bot.announce(date, ctx)
+	}()
+}
+
+// announce the new hashes based on the DJIA of the given date within the
+// location's maxDistance by posting them to all rooms.
+//
+// Each DJIA date is only announced once, even after a restart, as the latest
+// one is remembered in the account data. Older DJIA dates are skipped as well.
+// A DJIA date is only remembered after all hashes were calculated and posted
+// to at least one room, letting the next notification retry it otherwise.
+func (bot *matrixBot) announce(date time.Time, ctx context.Context) {
+	bot.announceMu.Lock()
+	defer bot.announceMu.Unlock()
+
+	if bot.announced == "" {
+		announced, err := bot.loadAnnounced(ctx)
+		if err != nil {
+			log.Printf("Cannot load the already posted hashes: %v", err)
+			return
+		}
+		bot.announced = announced
+	}
+
+	djiaDay := date.Format("2006-01-02")
+	if djiaDay <= bot.announced {
+		return
+	}
+
+	t := bot.location()
+	center, _ := graticuleFromPoint(t.lat, t.lon)
+	results, err := unlockedHashResults(neighbourhood(center, t.radius), t.globalhash, date, ctx)
+	if err != nil {
+		log.Printf("Cannot calculate the new hashes of %s: %v", djiaDay, err)
+		return
+	}
+
+	body := bot.formatResults(results, func(dist float64) bool {
+		return t.maxDistance == 0 || dist <= t.maxDistance
+	})
+	if body != "" {
+		body = fmt.Sprintf("New hashes near %s are available:\n%s", bot.conf.Matrix.Location, body)
+
+		posted := false
+		for _, roomId := range bot.conf.Matrix.Rooms {
+			err := bot.send(roomId, body, ctx)
+			if err != nil {
+				log.Printf("Cannot post new hashes in %s: %v", roomId, err)
+			} else {
+				posted = true
+			}
+		}
+		if !posted {
+			return
+		}
+	}
+
+	bot.announced = djiaDay
+	if err := bot.storeAnnounced(djiaDay, ctx); err != nil {
+		log.Printf("Cannot remember the posted hashes of %s: %v", djiaDay, err)
+	}
+}
+
+// run the bot until the context is done. Messages sent before the bot was
+// started are skipped.
+func (bot *matrixBot) run(ctx context.Context) {
+	since := ""
+	for attempt := 0; ; attempt++ {
+		timeout := bot.syncTimeout
+		if since == "" {
+			timeout = 0
+		}
+
+		resp, err := bot.sync(since, timeout, ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("Syncing with the Matrix homeserver failed: %v", err)
+			if !sleep(time.Duration(attempt+1)*5*time.Second, ctx) {
+				return
+			}
+			continue
+		}
+		attempt = -1
+
+		if since != "" {
+			bot.handleSync(resp, ctx)
+		}
+		since = resp.NextBatch
+	}
+}
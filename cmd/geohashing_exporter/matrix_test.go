@@ -0,0 +1,285 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMatrixBot for the testConfig's home location at 2022-07-16 noon.
+func testMatrixBot(homeserver string) *matrixBot {
+	conf := testConfig()
+	conf.Matrix = &matrixConfig{
+		Homeserver:  homeserver,
+		AccessToken: "secret",
+		UserId:      "@geohashing:example.org",
+		Rooms:       []string{"!room:example.org"},
+		Location:    "home",
+	}
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+
+	bot := newMatrixBot(conf)
+	bot.now = func() time.Time { return now }
+	return bot
+}
+
+func TestMatrixBotCommand(t *testing.T) {
+	setupTestProvider(t)
+
+	bot := testMatrixBot("http://localhost/")
+
+	tests := []struct {
+		body     string
+		ok       bool
+		contains []string
+		omits    []string
+	}{
+		{"hello", false, nil, nil},
+		{"!unknown", false, nil, nil},
+		{"!help", true, []string{"!hash", "!global", "!near"}, nil},
+		{"!hash 52 13", true, []string{
+			"2022-07-16 52 13: 52.991783, 13.205705 (54.1 km) https://www.openstreetmap.org/",
+			"2022-07-17 52 13: 52.112950, 13.071435 (49.4 km)",
+		}, []string{"global"}},
+		{"!hash 52", true, []string{"Usage"}, nil},
+		{"!hash 52 east", true, []string{"Cannot parse"}, nil},
+		{"!hash 91 13", true, []string{"Invalid graticule"}, nil},
+		{"!hash 52 13 yesterday", true, []string{"Invalid date"}, nil},
+		{"!global", true, []string{"2022-07-16 global: 88.520950, -105.946114 (4247.9 km)"}, []string{"52 13"}},
+		{"!near 50km", true, []string{"2022-07-17 52 13"}, []string{"2022-07-16 52 13", "global"}},
+		{"!near 5000", true, []string{"2022-07-16 52 13", "2022-07-16 global"}, []string{"2022-07-17 global"}},
+		{"!near far", true, []string{"Invalid distance"}, nil},
+		{"!near 0.001km", true, []string{"No hashes were found."}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			reply, ok := bot.command(test.body, context.Background())
+			if ok != test.ok {
+				t.Fatalf("expected ok = %t instead of %t: %q", test.ok, ok, reply)
+			}
+			for _, s := range test.contains {
+				if !strings.Contains(reply, s) {
+					t.Fatalf("expected %q in reply %q", s, reply)
+				}
+			}
+			for _, s := range test.omits {
+				if strings.Contains(reply, s) {
+					t.Fatalf("unexpected %q in reply %q", s, reply)
+				}
+			}
+		})
+	}
+}
+
+// matrixStandIn is a local homeserver, serving the given /sync responses by
+// their since parameter and passing each sent message's body to the channel.
+// A sync for an unknown batch blocks until the request is canceled. Account data
+// is kept for the server's lifetime.
+func matrixStandIn(t *testing.T, syncs map[string]string) (homeserver string, messages <-chan string) {
+	ch := make(chan string, 8)
+
+	var accountDataMu sync.Mutex
+	accountData := make(map[string]string)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v3/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unknown token", http.StatusUnauthorized)
+			return
+		}
+
+		resp, ok := syncs[r.URL.Query().Get("since")]
+		if !ok {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, resp)
+	})
+	mux.HandleFunc("/_matrix/client/v3/rooms/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/") {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+
+		var content map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil || content["msgtype"] != "m.notice" {
+			http.Error(w, "unexpected content", http.StatusBadRequest)
+			return
+		}
+		ch <- content["body"]
+		fmt.Fprint(w, `{"event_id":"$sent"}`)
+	})
+
+	mux.HandleFunc("/_matrix/client/v3/user/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/user/@geohashing:example.org/account_data/") {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+
+		accountDataMu.Lock()
+		defer accountDataMu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			content, ok := accountData[r.URL.Path]
+			if !ok {
+				http.Error(w, `{"errcode":"M_NOT_FOUND"}`, http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, content)
+
+		case http.MethodPut:
+			var content bytes.Buffer
+			if _, err := content.ReadFrom(r.Body); err != nil || !json.Valid(content.Bytes()) {
+				http.Error(w, "unexpected content", http.StatusBadRequest)
+				return
+			}
+			accountData[r.URL.Path] = content.String()
+			fmt.Fprint(w, `{}`)
+
+		default:
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server.URL, ch
+}
+
+// matrixSync creates a /sync response with text messages in the test room.
+func matrixSync(nextBatch string, messages map[string]string) string {
+	var events []string
+	for sender, body := range messages {
+		events = append(events, fmt.Sprintf(`{"type":"m.room.message","sender":%q,"event_id":"$%d","content":{"msgtype":"m.text","body":%q}}`,
+			sender, len(events), body))
+	}
+	return fmt.Sprintf(`{"next_batch":%q,"rooms":{"join":{"!room:example.org":{"timeline":{"events":[%s]}}}}}`,
+		nextBatch, strings.Join(events, ","))
+}
+
+func TestMatrixBot(t *testing.T) {
+	setupTestProvider(t)
+
+	homeserver, messages := matrixStandIn(t, map[string]string{
+		// Messages before the bot was started are skipped.
+		"":   matrixSync("s1", map[string]string{"@alice:example.org": "!help"}),
+		"s1": matrixSync("s2", map[string]string{"@alice:example.org": "!hash 52 13"}),
+		// The bot's own messages are ignored.
+		"s2": matrixSync("s3", map[string]string{"@geohashing:example.org": "!global"}),
+	})
+
+	bot := testMatrixBot(homeserver)
+	home := bot.conf.targets["home"]
+	home.maxDistance = 60_000
+	bot.conf.targets["home"] = home
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bot.run(ctx)
+
+	select {
+	case msg := <-messages:
+		if !strings.HasPrefix(msg, "2022-07-16 52 13: 52.991783, 13.205705") {
+			t.Fatalf("unexpected answer %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no answer was received")
+	}
+
+	// A weekday's DJIA unlocks today's hashes west of 30W and tomorrow's east of
+	// 30W, e.g., for home, within the location's max_distance_km.
+	nyc, _ := time.LoadLocation("America/New_York")
+	bot.announce(time.Date(2022, time.July, 14, 9, 30, 0, 0, nyc), context.Background())
+
+	select {
+	case msg := <-messages:
+		if !strings.HasPrefix(msg, "New hashes near home are available:\n") ||
+			!strings.Contains(msg, "2022-07-15 52 13") || strings.Contains(msg, "2022-07-14") || strings.Contains(msg, "2022-07-16") {
+			t.Fatalf("unexpected post %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no post was received")
+	}
+
+	// Already posted hashes are skipped.
+	bot.announce(time.Date(2022, time.July, 14, 9, 30, 0, 0, nyc), context.Background())
+
+	// Friday's DJIA unlocks the weekend, while the remote ones are omitted.
+	bot.announce(time.Date(2022, time.July, 15, 9, 30, 0, 0, nyc), context.Background())
+
+	select {
+	case msg := <-messages:
+		if !strings.HasPrefix(msg, "New hashes near home are available:\n") ||
+			!strings.Contains(msg, "2022-07-17 52 13") || strings.Contains(msg, "2022-07-15") || strings.Contains(msg, "2022-07-16 52 12") {
+			t.Fatalf("unexpected post %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no post was received")
+	}
+
+	// After a restart, the already posted hashes are still skipped.
+	restarted := testMatrixBot(homeserver)
+	restarted.conf.targets["home"] = home
+	restarted.announce(time.Date(2022, time.July, 15, 9, 30, 0, 0, nyc), context.Background())
+	restarted.announce(time.Date(2022, time.July, 14, 9, 30, 0, 0, nyc), context.Background())
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected message %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMatrixBotAnnounceFailures(t *testing.T) {
+	setupTestProvider(t)
+
+	homeserver, messages := matrixStandIn(t, nil)
+	bot := testMatrixBot(homeserver)
+	nyc, _ := time.LoadLocation("America/New_York")
+
+	// Without a DJIA, nothing is posted or remembered.
+	bot.announce(time.Date(2022, time.July, 13, 9, 30, 0, 0, nyc), context.Background())
+	if bot.announced != "" {
+		t.Fatalf("unexpected announced %q", bot.announced)
+	}
+
+	// Neither are hashes which could not be posted to any room, as the bot has
+	// not joined this one.
+	bot.conf.Matrix.Rooms = []string{"!unknown:example.org"}
+	bot.announce(time.Date(2022, time.July, 14, 9, 30, 0, 0, nyc), context.Background())
+	if bot.announced != "" {
+		t.Fatalf("unexpected announced %q", bot.announced)
+	}
+
+	// Thus, these are retried, e.g., after a restart.
+	restarted := testMatrixBot(homeserver)
+	restarted.announce(time.Date(2022, time.July, 14, 9, 30, 0, 0, nyc), context.Background())
+
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "2022-07-15 52 13") {
+			t.Fatalf("unexpected post %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no post was received")
+	}
+	if restarted.announced != "2022-07-14" {
+		t.Fatalf("unexpected announced %q", restarted.announced)
+	}
+}
@@ -0,0 +1,365 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the optional MQTT publisher for home automation, including
+// Home Assistant MQTT discovery. As only retained QoS 0 messages are published,
+// a minimal MQTT 3.1.1 client is implemented instead of using a library.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, already shifted into the fixed header.
+//
+// https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+const (
+	mqttConnect    byte = 1 << 4
+	mqttConnack    byte = 2 << 4
+	mqttPublish    byte = 3 << 4
+	mqttDisconnect byte = 14 << 4
+)
+
+// mqttAppendString appends a length-prefixed UTF-8 string.
+func mqttAppendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// mqttPacket creates a control packet with its fixed header.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+
+	// The remaining length is encoded as a variable length integer.
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(packet, body...)
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client, only able to publish.
+type mqttClient struct {
+	conn net.Conn
+}
+
+// mqttDial connects to the broker and sends a CONNECT packet with a clean
+// session, awaiting the CONNACK.
+func mqttDial(conf *mqttConfig, ctx context.Context) (client *mqttClient, err error) {
+	var conn net.Conn
+	if conf.Tls {
+		dialer := &tls.Dialer{}
+		conn, err = dialer.DialContext(ctx, "tcp", conf.Addr)
+	} else {
+		dialer := &net.Dialer{}
+		conn, err = dialer.DialContext(ctx, "tcp", conf.Addr)
+	}
+	if err != nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	flags := byte(0x02) // clean session
+	if conf.Username != "" {
+		flags |= 0x80
+		if conf.Password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := mqttAppendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 3.1.1
+	body = binary.BigEndian.AppendUint16(body, 60)
+	body = mqttAppendString(body, conf.ClientId)
+	if conf.Username != "" {
+		body = mqttAppendString(body, conf.Username)
+		if conf.Password != "" {
+			body = mqttAppendString(body, conf.Password)
+		}
+	}
+
+	_, err = conn.Write(mqttPacket(mqttConnect, body))
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	connack := make([]byte, 4)
+	_, err = io.ReadFull(conn, connack)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	if connack[0] != mqttConnack || connack[1] != 2 {
+		_ = conn.Close()
+		err = fmt.Errorf("expected CONNACK instead of %x", connack)
+		return
+	} else if connack[3] != 0 {
+		_ = conn.Close()
+		err = fmt.Errorf("connection refused with return code %d", connack[3])
+		return
+	}
+
+	client = &mqttClient{conn: conn}
+	return
+}
+
+// publish a retained message with QoS 0.
+func (client *mqttClient) publish(topic string, payload []byte) error {
+	const retain = 0x01
+
+	body := mqttAppendString(nil, topic)
+	body = append(body, payload...)
+	_, err := client.conn.Write(mqttPacket(mqttPublish|retain, body))
+	return err
+}
+
+// close the connection after a DISCONNECT.
+func (client *mqttClient) close() error {
+	_, err := client.conn.Write(mqttPacket(mqttDisconnect, nil))
+	closeErr := client.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// mqttMessage is a retained message to be published.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttIdPattern matches characters not allowed in topics and object ids.
+var mqttIdPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mqttId sanitizes a location's name for topics and Home Assistant ids.
+func mqttId(name string) string {
+	return mqttIdPattern.ReplaceAllString(name, "_")
+}
+
+// mqttPublisher publishes the current geohash and globalhash of each location,
+// whenever their values have changed.
+type mqttPublisher struct {
+	conf *config
+	// now is the current time, only to be altered for testing.
+	now func() time.Time
+
+	// published payloads by their topic, to only publish changes.
+	published map[string]string
+	// trigger a publication besides the interval.
+	trigger chan struct{}
+	// publishLock serializes publications.
+	publishLock sync.Mutex
+}
+
+// newMqttPublisher for the config, which must have mqtt.
+func newMqttPublisher(conf *config) *mqttPublisher {
+	return &mqttPublisher{
+		conf:      conf,
+		now:       time.Now,
+		published: make(map[string]string),
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// notify the publisher about a new DJIA, implementing djiaListener.
+func (pub *mqttPublisher) notify(_ time.Time, _ float64) {
+	select {
+	case pub.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// locationMessages creates the state and discovery messages for the current
+// hashes of a named location. Unavailable hashes are skipped, keeping their
+// previously retained values.
+func (pub *mqttPublisher) locationMessages(name string, t target, ctx context.Context) (msgs []mqttMessage) {
+	mqttConf := pub.conf.Mqtt
+	id := mqttId(name)
+
+	center, _ := graticuleFromPoint(t.lat, t.lon)
+	results := computeHashes(neighbourhood(center, 0), t.globalhash, pub.now().In(t.tz), ctx)
+
+	device := map[string]interface{}{
+		"identifiers":  []string{"geohashing_" + id},
+		"name":         "Geohashing " + name,
+		"manufacturer": "geohashing_exporter",
+	}
+
+	for _, result := range results {
+		if len(result.hashes) == 0 {
+			log.Printf("No %s hash is available for MQTT at %s: %v", result.name(), name, result.err)
+			continue
+		}
+
+		kind, title := "geohash", "Geohash"
+		if result.neighbour == nil {
+			kind, title = "globalhash", "Globalhash"
+		}
+		topic := fmt.Sprintf("%s/%s/%s", mqttConf.TopicPrefix, id, kind)
+		objectId := fmt.Sprintf("geohashing_%s_%s", id, kind)
+
+		hash := result.hashes[0]
+		dist := distance(t.lat, t.lon, hash.Lat, hash.Lon)
+
+		attributes := map[string]interface{}{
+			"latitude":     hash.Lat,
+			"longitude":    hash.Lon,
+			"gps_accuracy": 0,
+			"date":         hash.Date.Format("2006-01-02"),
+			"djia":         hash.Djia,
+			"djia_date":    hash.DjiaDate.Format("2006-01-02"),
+			"stale":        result.stale,
+		}
+		if result.neighbour != nil {
+			attributes["graticule"] = result.neighbour.graticule.String()
+		}
+
+		type state struct {
+			topic string
+			value interface{}
+		}
+		states := []state{
+			{topic + "/attributes", attributes},
+			{topic + "/distance", fmt.Sprintf("%.3f", dist/1000)},
+		}
+		if mqttConf.DiscoveryPrefix != "" {
+			states = append(states,
+				state{
+					fmt.Sprintf("%s/device_tracker/%s/config", mqttConf.DiscoveryPrefix, objectId),
+					map[string]interface{}{
+						"name":                  title,
+						"unique_id":             objectId,
+						"json_attributes_topic": topic + "/attributes",
+						"source_type":           "gps",
+						"device":                device,
+					},
+				},
+				state{
+					fmt.Sprintf("%s/sensor/%s_distance/config", mqttConf.DiscoveryPrefix, objectId),
+					map[string]interface{}{
+						"name":                title + " distance",
+						"unique_id":           objectId + "_distance",
+						"state_topic":         topic + "/distance",
+						"unit_of_measurement": "km",
+						"device_class":        "distance",
+						"state_class":         "measurement",
+						"device":              device,
+					},
+				})
+		}
+
+		for _, state := range states {
+			var payload []byte
+			if s, ok := state.value.(string); ok {
+				payload = []byte(s)
+			} else {
+				var err error
+				payload, err = json.Marshal(state.value)
+				if err != nil {
+					continue
+				}
+			}
+			msgs = append(msgs, mqttMessage{topic: state.topic, payload: payload})
+		}
+	}
+	return
+}
+
+// publish all changed messages of all locations within a single connection.
+// Messages failed to be published are retried on the next publication.
+func (pub *mqttPublisher) publish(ctx context.Context) error {
+	pub.publishLock.Lock()
+	defer pub.publishLock.Unlock()
+
+	names := make([]string, 0, len(pub.conf.targets))
+	for name := range pub.conf.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changed []mqttMessage
+	for _, name := range names {
+		for _, msg := range pub.locationMessages(name, pub.conf.targets[name], ctx) {
+			if !bytes.Equal([]byte(pub.published[msg.topic]), msg.payload) {
+				changed = append(changed, msg)
+			}
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	client, err := mqttDial(pub.conf.Mqtt, ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range changed {
+		err = client.publish(msg.topic, msg.payload)
+		if err != nil {
+			_ = client.conn.Close()
+			return err
+		}
+	}
+
+	err = client.close()
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range changed {
+		pub.published[msg.topic] = string(msg.payload)
+	}
+	log.Printf("Published %d MQTT messages", len(changed))
+	return nil
+}
+
+// run the publisher until the context is done, publishing each interval or
+// when triggered by notify.
+func (pub *mqttPublisher) run(ctx context.Context) {
+	ticker := time.NewTicker(pub.conf.Mqtt.Interval)
+	defer ticker.Stop()
+
+	for {
+		publishCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := pub.publish(publishCtx)
+		cancel()
+		if err != nil {
+			log.Printf("Publishing to MQTT failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-pub.trigger:
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMqttPacket(t *testing.T) {
+	tests := []struct {
+		bodyLen int
+		header  []byte
+	}{
+		{0, []byte{0xe0, 0x00}},
+		{127, []byte{0xe0, 0x7f}},
+		{128, []byte{0xe0, 0x80, 0x01}},
+		{16383, []byte{0xe0, 0xff, 0x7f}},
+		{16384, []byte{0xe0, 0x80, 0x80, 0x01}},
+	}
+
+	for _, test := range tests {
+		packet := mqttPacket(mqttDisconnect, make([]byte, test.bodyLen))
+		if len(packet) != len(test.header)+test.bodyLen || string(packet[:len(test.header)]) != string(test.header) {
+			t.Fatalf("expected header %x instead of %x", test.header, packet[:len(test.header)])
+		}
+	}
+}
+
+func TestMqttId(t *testing.T) {
+	if id := mqttId("Home sweet/home+#"); id != "Home_sweet_home_" {
+		t.Fatalf("unexpected id %q", id)
+	}
+}
+
+// mqttSession is what a mqttStandIn has received within a session.
+type mqttSession struct {
+	clientId string
+	username string
+	password string
+	// retained messages by their topic.
+	retained map[string][]byte
+}
+
+// mqttStandIn is a local MQTT broker, accepting sessions of the minimal
+// mqttClient and passing each finished session to the channel.
+func mqttStandIn(t *testing.T) (addr string, sessions <-chan mqttSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	ch := make(chan mqttSession, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mqttStandInSession(conn, ch)
+		}
+	}()
+
+	return listener.Addr().String(), ch
+}
+
+// mqttStandInSession handles a single connection.
+func mqttStandInSession(conn net.Conn, ch chan<- mqttSession) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	readPacket := func() (header byte, body []byte, err error) {
+		header, err = reader.ReadByte()
+		if err != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			var b byte
+			b, err = reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(b&0x7f) * multiplier
+			multiplier *= 128
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		body = make([]byte, length)
+		_, err = io.ReadFull(reader, body)
+		return
+	}
+	readString := func(body []byte) (string, []byte) {
+		n := binary.BigEndian.Uint16(body)
+		return string(body[2 : 2+n]), body[2+n:]
+	}
+
+	session := mqttSession{retained: make(map[string][]byte)}
+
+	header, body, err := readPacket()
+	if err != nil || header != mqttConnect {
+		return
+	}
+	protocol, body := readString(body)
+	if protocol != "MQTT" || body[0] != 4 {
+		return
+	}
+	flags := body[1]
+	session.clientId, body = readString(body[4:])
+	if flags&0x80 != 0 {
+		session.username, body = readString(body)
+	}
+	if flags&0x40 != 0 {
+		session.password, _ = readString(body)
+	}
+	_, _ = conn.Write([]byte{mqttConnack, 2, 0, 0})
+
+	for {
+		header, body, err := readPacket()
+		if err != nil {
+			return
+		}
+
+		switch header & 0xf0 {
+		case mqttPublish:
+			if header&0x01 == 0 {
+				return
+			}
+			topic, payload := readString(body)
+			session.retained[topic] = payload
+		case mqttDisconnect:
+			ch <- session
+			return
+		default:
+			return
+		}
+	}
+}
+
+func TestMqttPublisher(t *testing.T) {
+	setupTestProvider(t)
+
+	addr, sessions := mqttStandIn(t)
+
+	conf := testConfig()
+	conf.Mqtt = &mqttConfig{Addr: addr, Username: "geohashing", Password: "hunter2"}
+	if err := conf.Mqtt.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+
+	pub := newMqttPublisher(conf)
+	pub.now = func() time.Time { return now }
+
+	if err := pub.publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var session mqttSession
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no session was received")
+	}
+
+	if session.clientId != "geohashing_exporter" || session.username != "geohashing" || session.password != "hunter2" {
+		t.Fatalf("unexpected session %#v", session)
+	}
+	if len(session.retained) != 8 {
+		t.Fatalf("expected 8 retained messages instead of %d: %v", len(session.retained), session.retained)
+	}
+
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(session.retained["geohashing/home/geohash/attributes"], &attributes); err != nil {
+		t.Fatal(err)
+	}
+	if lat, _ := attributes["latitude"].(float64); math.Abs(lat-52.99178) > 0.00001 || attributes["date"] != "2022-07-16" || attributes["graticule"] != "52,13" {
+		t.Fatalf("unexpected attributes %v", attributes)
+	}
+	if dist := string(session.retained["geohashing/home/geohash/distance"]); dist != "54.093" {
+		t.Fatalf("unexpected distance %q", dist)
+	}
+
+	var discovery map[string]interface{}
+	if err := json.Unmarshal(session.retained["homeassistant/device_tracker/geohashing_home_globalhash/config"], &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery["json_attributes_topic"] != "geohashing/home/globalhash/attributes" || discovery["unique_id"] != "geohashing_home_globalhash" {
+		t.Fatalf("unexpected discovery %v", discovery)
+	}
+	if err := json.Unmarshal(session.retained["homeassistant/sensor/geohashing_home_geohash_distance/config"], &discovery); err != nil {
+		t.Fatal(err)
+	} else if discovery["state_topic"] != "geohashing/home/geohash/distance" || discovery["unit_of_measurement"] != "km" {
+		t.Fatalf("unexpected discovery %v", discovery)
+	}
+
+	// Unchanged values will not be published again.
+	if err := pub.publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// On the next day, only the states have changed.
+	now = now.AddDate(0, 0, 1)
+	if err := pub.publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no session was received")
+	}
+	if len(session.retained) != 4 {
+		t.Fatalf("expected 4 retained messages instead of %d: %v", len(session.retained), session.retained)
+	}
+	if _, ok := session.retained["geohashing/home/geohash/attributes"]; !ok {
+		t.Fatalf("missing geohash attributes in %v", session.retained)
+	}
+}
@@ -0,0 +1,498 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the optional OpenTelemetry export of the geohash gauges
+// and of traces via OTLP/HTTP. As only a small part of OTLP is needed, its
+// JSON encoding is implemented instead of using the OpenTelemetry SDK.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Span kinds as defined by OTLP.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+)
+
+// otlpMaxSpans limits the finished spans buffered between two exports. Further
+// spans are dropped.
+const otlpMaxSpans = 4096
+
+// otlpTraceDelay between two exports of the buffered spans.
+const otlpTraceDelay = 5 * time.Second
+
+// otlpSpan is a single span of a trace, created by startSpan.
+type otlpSpan struct {
+	traceId  [16]byte
+	spanId   [8]byte
+	parentId [8]byte
+
+	name  string
+	kind  int
+	start time.Time
+	end   time.Time
+
+	// attributes are either strings, bools, ints, or float64s.
+	attributes     map[string]interface{}
+	err            error
+	attributesLock sync.Mutex
+}
+
+// otlpSpanKey stores the current otlpSpan in a context.
+type otlpSpanKey struct{}
+
+// otlpUntracedKey marks a context whose operations are not traced.
+type otlpUntracedKey struct{}
+
+// withoutTracing derives a context in which no spans are started, e.g., for the
+// exporter's own collection not to flood the receiver with its traces.
+func withoutTracing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, otlpUntracedKey{}, true)
+}
+
+// spanFromContext returns the context's current span or nil.
+func spanFromContext(ctx context.Context) *otlpSpan {
+	span, _ := ctx.Value(otlpSpanKey{}).(*otlpSpan)
+	return span
+}
+
+// startSpan as a child of the context's current span, if any, or as a new
+// trace's root. Without an otlpInstance or within a withoutTracing context,
+// the returned span is nil, being a valid no-op receiver.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *otlpSpan) {
+	if otlpInstance == nil || ctx.Value(otlpUntracedKey{}) != nil {
+		return ctx, nil
+	}
+
+	span := &otlpSpan{
+		name:       name,
+		kind:       kind,
+		start:      otlpInstance.now(),
+		attributes: make(map[string]interface{}),
+	}
+	if parent := spanFromContext(ctx); parent != nil {
+		span.traceId = parent.traceId
+		span.parentId = parent.spanId
+	} else {
+		_, _ = rand.Read(span.traceId[:])
+	}
+	_, _ = rand.Read(span.spanId[:])
+
+	return context.WithValue(ctx, otlpSpanKey{}, span), span
+}
+
+// parseTraceparent extracts the trace and parent span id of a W3C Trace
+// Context traceparent header, e.g., to continue a Prometheus' scrape trace.
+func parseTraceparent(header string) (traceId [16]byte, parentId [8]byte, ok bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return
+	}
+
+	_, traceErr := hex.Decode(traceId[:], []byte(parts[1]))
+	_, parentErr := hex.Decode(parentId[:], []byte(parts[2]))
+	ok = traceErr == nil && parentErr == nil && traceId != [16]byte{} && parentId != [8]byte{}
+	return
+}
+
+// setAttribute of the span.
+func (span *otlpSpan) setAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.attributesLock.Lock()
+	defer span.attributesLock.Unlock()
+
+	span.attributes[key] = value
+}
+
+// finish the span with its operation's error, queuing it for the export.
+func (span *otlpSpan) finish(err error) {
+	if span == nil {
+		return
+	}
+
+	span.attributesLock.Lock()
+	span.end = otlpInstance.now()
+	span.err = err
+	span.attributesLock.Unlock()
+
+	otlpInstance.queue(span)
+}
+
+// otlpStatusRecorder records a response's status code for a span, while
+// still supporting streaming responses.
+type otlpStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rec *otlpStatusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher, if supported by the wrapped ResponseWriter.
+func (rec *otlpStatusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// traceHandler creates a server span for each request of a handler.
+func traceHandler(name string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if otlpInstance == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if traceId, parentId, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, otlpSpanKey{}, &otlpSpan{traceId: traceId, spanId: parentId})
+		}
+		ctx, span := startSpan(ctx, r.Method+" "+name, otlpSpanKindServer)
+		span.setAttribute("http.method", r.Method)
+		span.setAttribute("http.route", name)
+		// Only the path is recorded, as the query might contain coordinates.
+		span.setAttribute("http.target", r.URL.EscapedPath())
+
+		rec := &otlpStatusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.setAttribute("http.status_code", rec.status)
+
+		var err error
+		if rec.status >= 500 {
+			err = fmt.Errorf("%s", http.StatusText(rec.status))
+		}
+		span.finish(err)
+	})
+}
+
+// otlpKeyValue is an OTLP attribute.
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpAttributes converts attributes into sorted OTLP attributes.
+func otlpAttributes(attributes map[string]interface{}) (kvs []otlpKeyValue) {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch v := attributes[key].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			// 64 bit integers are encoded as strings in OTLP's JSON encoding.
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: key, Value: value})
+	}
+	return
+}
+
+// otlpTime encodes a timestamp as the string of its Unix nanoseconds.
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpJsonSpan is a span within an OTLP ExportTraceServiceRequest.
+type otlpJsonSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// otlpJsonDataPoint is a gauge's data point within an OTLP
+// ExportMetricsServiceRequest.
+type otlpJsonDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	TimeUnixNano string         `json:"timeUnixNano"`
+	AsDouble     float64        `json:"asDouble"`
+}
+
+// otlpJsonMetric is a gauge within an OTLP ExportMetricsServiceRequest.
+type otlpJsonMetric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Gauge       struct {
+		DataPoints []otlpJsonDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+// otlpExporter exports the buffered spans and the geohash gauges of each
+// configured location to an OTLP/HTTP receiver.
+type otlpExporter struct {
+	conf   *config
+	client *http.Client
+	// now is the current time, only to be altered for testing.
+	now func() time.Time
+
+	// spans are finished and buffered until the next export.
+	spans     []*otlpSpan
+	dropped   int
+	spansLock sync.Mutex
+}
+
+// otlpInstance is the exporter for all spans; nil if OTLP is disabled.
+var otlpInstance *otlpExporter
+
+// newOtlpExporter for the config, which must have otlp.
+func newOtlpExporter(conf *config) *otlpExporter {
+	return &otlpExporter{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// queue a finished span for the next export.
+func (exporter *otlpExporter) queue(span *otlpSpan) {
+	exporter.spansLock.Lock()
+	defer exporter.spansLock.Unlock()
+
+	if len(exporter.spans) >= otlpMaxSpans {
+		exporter.dropped++
+		return
+	}
+	exporter.spans = append(exporter.spans, span)
+}
+
+// resource of all exported signals within its scope.
+func (exporter *otlpExporter) resource() (resource, scope map[string]interface{}) {
+	resource = map[string]interface{}{
+		"attributes": otlpAttributes(map[string]interface{}{
+			"service.name": exporter.conf.Otlp.ServiceName,
+		}),
+	}
+	scope = map[string]interface{}{"name": "geohashing_exporter"}
+	return
+}
+
+// post a JSON encoded OTLP request to the receiver's path for its signal.
+func (exporter *otlpExporter) post(path string, payload interface{}, ctx context.Context) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(exporter.conf.Otlp.Endpoint, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range exporter.conf.Otlp.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := exporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Drain the body to reuse the connection for the next export.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP receiver responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// exportTraces sends all buffered spans. Failed spans are dropped, not to
+// pile up during an outage.
+func (exporter *otlpExporter) exportTraces(ctx context.Context) error {
+	exporter.spansLock.Lock()
+	spans, dropped := exporter.spans, exporter.dropped
+	exporter.spans, exporter.dropped = nil, 0
+	exporter.spansLock.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped %d spans exceeding the OTLP buffer", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	jsonSpans := make([]otlpJsonSpan, 0, len(spans))
+	for _, span := range spans {
+		span.attributesLock.Lock()
+		jsonSpan := otlpJsonSpan{
+			TraceId:           hex.EncodeToString(span.traceId[:]),
+			SpanId:            hex.EncodeToString(span.spanId[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: otlpTime(span.start),
+			EndTimeUnixNano:   otlpTime(span.end),
+			Attributes:        otlpAttributes(span.attributes),
+		}
+		if span.parentId != [8]byte{} {
+			jsonSpan.ParentSpanId = hex.EncodeToString(span.parentId[:])
+		}
+		if span.err != nil {
+			jsonSpan.Status.Code = 2
+			jsonSpan.Status.Message = span.err.Error()
+		}
+		span.attributesLock.Unlock()
+
+		jsonSpans = append(jsonSpans, jsonSpan)
+	}
+
+	resource, scope := exporter.resource()
+	return exporter.post("/v1/traces", map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": resource,
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": scope,
+				"spans": jsonSpans,
+			}},
+		}},
+	}, ctx)
+}
+
+// exportMetrics sends the gauges of a geohashCollector for each named
+// location, labeled by its name as target. This collection is not traced.
+func (exporter *otlpExporter) exportMetrics(ctx context.Context) (err error) {
+	ctx = withoutTracing(ctx)
+
+	names := make([]string, 0, len(exporter.conf.targets))
+	for name := range exporter.conf.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := otlpTime(exporter.now())
+	var metrics []*otlpJsonMetric
+	metricsByName := make(map[string]*otlpJsonMetric)
+
+	for _, name := range names {
+		var families []*dto.MetricFamily
+		families, err = newGeohashCollector(exporter.conf.targets[name]).gather(ctx)
+		if err != nil {
+			return
+		}
+
+		for _, family := range families {
+			metric, ok := metricsByName[family.GetName()]
+			if !ok {
+				metric = &otlpJsonMetric{Name: family.GetName(), Description: family.GetHelp()}
+				metricsByName[family.GetName()] = metric
+				metrics = append(metrics, metric)
+			}
+
+			for _, m := range family.GetMetric() {
+				attributes := map[string]interface{}{"target": name}
+				for _, label := range m.GetLabel() {
+					attributes[label.GetName()] = label.GetValue()
+				}
+
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpJsonDataPoint{
+					Attributes:   otlpAttributes(attributes),
+					TimeUnixNano: now,
+					AsDouble:     m.GetGauge().GetValue(),
+				})
+			}
+		}
+	}
+	if len(metrics) == 0 {
+		return
+	}
+
+	resource, scope := exporter.resource()
+	err = exporter.post("/v1/metrics", map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   scope,
+				"metrics": metrics,
+			}},
+		}},
+	}, ctx)
+	return
+}
+
+// run the exporter until the context is done, exporting the metrics at startup
+// and each interval and the spans more frequently.
+func (exporter *otlpExporter) run(ctx context.Context) {
+	exportMetrics := func() {
+		exportCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := exporter.exportMetrics(exportCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Exporting OTLP metrics failed: %v", err)
+		}
+	}
+	exportMetrics()
+
+	metricsTicker := time.NewTicker(exporter.conf.Otlp.Interval)
+	defer metricsTicker.Stop()
+	tracesTicker := time.NewTicker(otlpTraceDelay)
+	defer tracesTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-metricsTicker.C:
+			exportMetrics()
+
+		case <-tracesTicker.C:
+			exportCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err := exporter.exportTraces(exportCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Exporting OTLP traces failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", true},
+		{"", false},
+		{"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", false},
+		{"00-00000000000000000000000000000000-b7ad6b7169203331-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01", false},
+		{"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			if _, _, ok := parseTraceparent(test.header); ok != test.ok {
+				t.Fatalf("expected ok = %t instead of %t", test.ok, ok)
+			}
+		})
+	}
+}
+
+// otlpStandIn is a local OTLP/HTTP receiver, passing each JSON request to the
+// channel of its path.
+func otlpStandIn(t *testing.T) (endpoint string, traces, metrics <-chan map[string]interface{}) {
+	tracesCh := make(chan map[string]interface{}, 8)
+	metricsCh := make(chan map[string]interface{}, 8)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unexpected headers", http.StatusBadRequest)
+			return
+		}
+
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/traces":
+			tracesCh <- req
+		case "/v1/metrics":
+			metricsCh <- req
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, tracesCh, metricsCh
+}
+
+// setupTestOtlp enables the otlpInstance and the exporterHooks for an OTLP
+// receiver until the test has finished.
+func setupTestOtlp(t *testing.T, conf *config, endpoint string) {
+	conf.Otlp = &otlpConfig{Endpoint: endpoint, Headers: map[string]string{"Authorization": "Bearer secret"}}
+	if err := conf.Otlp.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	otlpInstance = newOtlpExporter(conf)
+	geohash.SetHooks(exporterHooks())
+	t.Cleanup(func() {
+		otlpInstance = nil
+		geohash.SetHooks(geohash.Hooks{})
+	})
+}
+
+// otlpAttribute looks up an attribute's value object within a JSON decoded
+// OTLP object.
+func otlpAttribute(obj map[string]interface{}, key string) map[string]interface{} {
+	attributes, _ := obj["attributes"].([]interface{})
+	for _, attribute := range attributes {
+		kv := attribute.(map[string]interface{})
+		if kv["key"] == key {
+			return kv["value"].(map[string]interface{})
+		}
+	}
+	return nil
+}
+
+func TestOtlpTraces(t *testing.T) {
+	setupTestProvider(t)
+
+	endpoint, traces, _ := otlpStandIn(t)
+	conf := testConfig()
+	setupTestOtlp(t, conf, endpoint)
+
+	mux := http.NewServeMux()
+	(&apiServer{conf: conf}).register(mux)
+
+	const traceId, parentId = "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/geohash?target=home&date=2022-07-16", nil)
+	req.Header.Set("traceparent", "00-"+traceId+"-"+parentId+"-01")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 instead of %d: %s", rec.Code, rec.Body)
+	}
+
+	if err := otlpInstance.exportTraces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	payload := <-traces
+
+	resourceSpans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	if name := otlpAttribute(resourceSpans["resource"].(map[string]interface{}), "service.name"); name["stringValue"] != "geohashing_exporter" {
+		t.Fatalf("unexpected service.name %v", name)
+	}
+
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	spansByName := make(map[string]map[string]interface{})
+	for _, s := range spans {
+		span := s.(map[string]interface{})
+		if span["traceId"] != traceId {
+			t.Fatalf("expected trace id %s instead of %v", traceId, span["traceId"])
+		}
+		spansByName[span["name"].(string)] = span
+	}
+
+	server, ok := spansByName["GET /api/v1/geohash"]
+	if !ok {
+		t.Fatalf("missing server span in %v", spansByName)
+	}
+	if server["parentSpanId"] != parentId || server["kind"] != 2.0 {
+		t.Fatalf("unexpected server span %v", server)
+	}
+	if status := otlpAttribute(server, "http.status_code"); status["intValue"] != "200" {
+		t.Fatalf("unexpected http.status_code %v", status)
+	}
+	if target := otlpAttribute(server, "http.target"); target["stringValue"] != "/api/v1/geohash" {
+		t.Fatalf("unexpected http.target %v", target)
+	}
+
+	compute, ok := spansByName["computeHashes 52,13"]
+	if !ok || compute["parentSpanId"] != server["spanId"] {
+		t.Fatalf("unexpected computeHashes span %v", compute)
+	}
+	for _, name := range []string{"normalizeDate", "djiaCache.Get"} {
+		span, ok := spansByName[name]
+		if !ok {
+			t.Fatalf("missing %s span in %v", name, spansByName)
+		}
+		if span["parentSpanId"] == server["spanId"] || span["parentSpanId"] == parentId {
+			t.Fatalf("unexpected parent of %s span %v", name, span)
+		}
+	}
+	if hit := otlpAttribute(spansByName["djiaCache.Get"], "cache.hit"); hit == nil {
+		t.Fatalf("missing cache.hit in %v", spansByName["djiaCache.Get"])
+	}
+
+	// All spans were exported and nothing is left.
+	if err := otlpInstance.exportTraces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-traces:
+		t.Fatalf("unexpected traces %v", payload)
+	default:
+	}
+}
+
+func TestOtlpMetrics(t *testing.T) {
+	setupTestProvider(t)
+
+	endpoint, traces, metrics := otlpStandIn(t)
+	conf := testConfig()
+	setupTestOtlp(t, conf, endpoint)
+
+	if err := otlpInstance.exportMetrics(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	payload := <-metrics
+
+	resourceMetrics := payload["resourceMetrics"].([]interface{})[0].(map[string]interface{})
+	scopeMetrics := resourceMetrics["scopeMetrics"].([]interface{})[0].(map[string]interface{})
+
+	found := false
+	for _, m := range scopeMetrics["metrics"].([]interface{}) {
+		metric := m.(map[string]interface{})
+		if metric["name"] != "geohashing_available" {
+			continue
+		}
+		found = true
+
+		dataPoints := metric["gauge"].(map[string]interface{})["dataPoints"].([]interface{})
+		for _, dp := range dataPoints {
+			dataPoint := dp.(map[string]interface{})
+			if target := otlpAttribute(dataPoint, "target"); target["stringValue"] != "home" {
+				t.Fatalf("unexpected target %v", target)
+			}
+			if location := otlpAttribute(dataPoint, "location"); location == nil {
+				t.Fatalf("missing location in %v", dataPoint)
+			}
+		}
+		if len(dataPoints) != 10 {
+			t.Fatalf("expected ten data points instead of %d", len(dataPoints))
+		}
+	}
+	if !found {
+		t.Fatalf("missing geohashing_available in %v", scopeMetrics)
+	}
+
+	// The exporter's own collection is not traced.
+	if err := otlpInstance.exportTraces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-traces:
+		t.Fatalf("unexpected traces %v", payload)
+	default:
+	}
+}
+
+func TestOtlpRun(t *testing.T) {
+	setupTestProvider(t)
+
+	endpoint, _, metrics := otlpStandIn(t)
+	conf := testConfig()
+	setupTestOtlp(t, conf, endpoint)
+	conf.Otlp.Interval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		otlpInstance.run(ctx)
+		close(done)
+	}()
+
+	// The metrics are exported at startup, not only after the first interval.
+	select {
+	case <-metrics:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no metrics were exported")
+	}
+
+	cancel()
+	<-done
+}
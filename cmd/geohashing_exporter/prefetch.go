@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the optional background DJIA prefetcher, polling for each
+// new DJIA value shortly after the NYSE opening.
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/oxzi/geohashing_exporter/geohash"
+)
+
+// djiaListener is notified by the prefetcher about each new DJIA value, which
+// makes new geohashes available.
+type djiaListener func(date time.Time, djia float64)
+
+// prefetcher polls the DJIA after each NYSE opening to warm the cache and to
+// announce new geohashes to its listeners.
+type prefetcher struct {
+	provider *geohash.GeoHashProvider
+
+	// delay after the NYSE opening before polling the first time.
+	delay time.Duration
+	// minBackoff and maxBackoff between failed polls.
+	minBackoff, maxBackoff time.Duration
+
+	listeners     []djiaListener
+	listenersLock sync.Mutex
+}
+
+// newPrefetcher with reasonable defaults for the geohash provider.
+func newPrefetcher(provider *geohash.GeoHashProvider) *prefetcher {
+	return &prefetcher{
+		provider:   provider,
+		delay:      time.Minute,
+		minBackoff: 30 * time.Second,
+		maxBackoff: 15 * time.Minute,
+	}
+}
+
+// addListener to be notified about new DJIA values.
+func (p *prefetcher) addListener(listener djiaListener) {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+
+	p.listeners = append(p.listeners, listener)
+}
+
+// announce a new DJIA value to the log and all listeners.
+func (p *prefetcher) announce(date time.Time, djia float64) {
+	log.Printf("DJIA of %s is %.2f, new geohashes are available", date.Format("2006-01-02"), djia)
+
+	p.listenersLock.Lock()
+	listeners := append([]djiaListener(nil), p.listeners...)
+	p.listenersLock.Unlock()
+
+	for _, listener := range listeners {
+		listener(date, djia)
+	}
+}
+
+// exponentialBackoff for the given attempt, starting at zero, exponentially
+// growing from minBackoff up to maxBackoff.
+func exponentialBackoff(attempt int, minBackoff, maxBackoff time.Duration) time.Duration {
+	backoff := minBackoff
+	for i := 0; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// backoff for the given attempt of the prefetcher, see exponentialBackoff.
+func (p *prefetcher) backoff(attempt int) time.Duration {
+	return exponentialBackoff(attempt, p.minBackoff, p.maxBackoff)
+}
+
+// sleep for the duration or until the context is done, returning false then.
+func sleep(d time.Duration, ctx context.Context) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryUntil calls try until it succeeds, the deadline would be passed by the
+// next backoff, or the context is done. Each failure is logged for the named
+// operation, e.g., "polling the DJIA". The last error is returned.
+func retryUntil(operation string, deadline time.Time, minBackoff, maxBackoff time.Duration, try func(ctx context.Context) error, ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		err := try(ctx)
+		if err == nil {
+			return nil
+		}
+
+		backoff := exponentialBackoff(attempt, minBackoff, maxBackoff)
+		if time.Now().Add(backoff).After(deadline) {
+			log.Printf("Giving up %s: %v", operation, err)
+			return err
+		}
+
+		log.Printf("Failed %s, retrying in %v: %v", operation, backoff, err)
+		if !sleep(backoff, ctx) {
+			return ctx.Err()
+		}
+	}
+}
+
+// poll the DJIA of the opening's date until it is available, the next opening
+// is reached, or the context is done.
+func (p *prefetcher) poll(opening time.Time, ctx context.Context) {
+	var djia float64
+	err := retryUntil("polling the DJIA of "+opening.Format("2006-01-02"), geohash.NextDowOpening(opening), p.minBackoff, p.maxBackoff,
+		func(ctx context.Context) (err error) {
+			pollCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+
+			djia, err = p.provider.Djia(opening, pollCtx)
+			return
+		}, ctx)
+	if err == nil {
+		p.announce(opening, djia)
+	}
+}
+
+// run the prefetcher until the context is done. First, the latest DJIA will be
+// fetched. Afterwards, each following NYSE opening will be awaited.
+func (p *prefetcher) run(ctx context.Context) {
+	opening := geohash.LastDowOpening(time.Now())
+	for {
+		p.poll(opening, ctx)
+
+		opening = geohash.NextDowOpening(time.Now())
+		log.Printf("Next DJIA prefetch is scheduled for %v", opening.Add(p.delay))
+		if !sleep(time.Until(opening.Add(p.delay)), ctx) {
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPrefetcherBackoff(t *testing.T) {
+	p := &prefetcher{
+		minBackoff: 30 * time.Second,
+		maxBackoff: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 15 * time.Minute},
+		{100, 15 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d", test.attempt), func(t *testing.T) {
+			if backoff := p.backoff(test.attempt); backoff != test.backoff {
+				t.Fatalf("expected %v instead of %v", test.backoff, backoff)
+			}
+		})
+	}
+}
+
+func TestPrefetcherAnnounce(t *testing.T) {
+	p := &prefetcher{}
+
+	var announced []float64
+	for i := 0; i < 2; i++ {
+		p.addListener(func(_ time.Time, djia float64) {
+			announced = append(announced, djia)
+		})
+	}
+
+	p.announce(time.Now(), 23.42)
+
+	if len(announced) != 2 || announced[0] != 23.42 || announced[1] != 23.42 {
+		t.Fatalf("unexpected announcements %v", announced)
+	}
+}
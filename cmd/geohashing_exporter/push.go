@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the push subcommand, periodically pushing the metrics of
+// each configured location to a Prometheus Pushgateway for hosts which cannot
+// be scraped.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushTargets pushes the metrics of each named location to the Pushgateway,
+// grouped by the job and the location's name as the `target` label. Each push
+// replaces the previous metrics of its group.
+//
+// All locations are pushed, even if some fail. The first error is returned.
+func pushTargets(conf *config, gateway, job string, ctx context.Context) (err error) {
+	names := make([]string, 0, len(conf.targets))
+	for name := range conf.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		collector := newGeohashCollector(conf.targets[name])
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return collector.gather(ctx)
+		})
+
+		pushErr := push.New(gateway, job).
+			Grouping("target", name).
+			Gatherer(gatherer).
+			PushContext(ctx)
+		if pushErr != nil {
+			log.Printf("Pushing %s failed: %v", name, pushErr)
+			if err == nil {
+				err = fmt.Errorf("pushing %s failed: %w", name, pushErr)
+			}
+		}
+	}
+	return
+}
+
+// pushCommand implements the push subcommand, pushing the metrics of all
+// configured locations each interval or only once.
+func pushCommand(args []string) error {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML configuration file with named locations")
+	gateway := flags.String("gateway", "http://localhost:9091", "Pushgateway URL, optionally with basic auth credentials")
+	job := flags.String("job", "geohashing", "Job label of the pushed metrics")
+	interval := flags.Duration("interval", 15*time.Minute, "Interval between pushes")
+	once := flags.Bool("once", false, "Push only once, e.g., when scheduled by cron")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *configFile == "" {
+		return fmt.Errorf("-config is required")
+	}
+	if *interval < time.Second {
+		return fmt.Errorf("interval must be at least one second")
+	}
+
+	// The configuration must be read before dropping privileges.
+	conf, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if len(conf.targets) == 0 {
+		return fmt.Errorf("no locations are configured in %s", *configFile)
+	}
+
+	toLeastPrivilege()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = pushTargets(conf, *gateway, *job, ctx)
+		cancel()
+
+		if *once {
+			return err
+		} else if err == nil {
+			log.Printf("Pushed %d locations to %s", len(conf.targets), *gateway)
+		}
+
+		<-ticker.C
+	}
+}
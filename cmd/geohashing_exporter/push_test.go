@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPushTargets(t *testing.T) {
+	setupTestProvider(t)
+
+	var pushesLock sync.Mutex
+	pushes := make(map[string]string)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+			return
+		}
+		if user, password, _ := r.BasicAuth(); user != "user" || password != "hunter2" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// The protobuf encoded body contains the names and labels verbatim.
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pushesLock.Lock()
+		pushes[r.URL.Path] = string(body)
+		pushesLock.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	conf := testConfig()
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	conf.targets["office"] = target{lat: 50.110924, lon: 8.682127, tz: tz, radius: 0, globalhash: false}
+
+	url := strings.Replace(gateway.URL, "http://", "http://user:hunter2@", 1)
+	if err := pushTargets(conf, url, "geohashing", context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pushes) != 2 {
+		t.Fatalf("expected two pushes instead of %v", pushes)
+	}
+	for _, name := range []string{"home", "office"} {
+		body, ok := pushes["/metrics/job/geohashing/target/"+name]
+		if !ok {
+			t.Fatalf("missing push of %s in %v", name, pushes)
+		}
+		if !strings.Contains(body, "geohashing_available") {
+			t.Fatalf("unexpected push of %s: %q", name, body)
+		}
+	}
+	if strings.Contains(pushes["/metrics/job/geohashing/target/office"], "global") {
+		t.Fatalf("unexpected globalhash for office: %q", pushes["/metrics/job/geohashing/target/office"])
+	}
+
+	// Failing pushes are reported.
+	if err := pushTargets(conf, gateway.URL, "geohashing", context.Background()); err == nil {
+		t.Fatal("expected an error without credentials")
+	}
+}
@@ -0,0 +1,318 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the remote-write subcommand, periodically sending the
+// geohashes of each configured location via the Prometheus remote write
+// protocol. The few required protobuf messages are encoded by hand.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteSample is a single sample of a series identified by its labels,
+// including the __name__.
+type remoteWriteSample struct {
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+// remoteWriteRequest encodes the samples as a prometheus.WriteRequest protobuf
+// message, one series per sample.
+//
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+func remoteWriteRequest(samples []remoteWriteSample) []byte {
+	var req []byte
+	for _, sample := range samples {
+		names := make([]string, 0, len(sample.labels))
+		for name := range sample.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, sample.labels[name])
+
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+		s = protowire.AppendFixed64(s, math.Float64bits(sample.value))
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(sample.timestamp.UnixMilli()))
+
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, s)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
+
+// remoteWriteSamples creates the samples of the geohashes whose validity has
+// started at now for each named location. Each sample's timestamp is the start
+// of its geohash's day in the location's time zone.
+//
+// In contrast to the geohashCollector, the series are not labeled by their
+// changing date and day_offset. Thus, each series records the history of a
+// location's geohashes with one sample per day.
+func remoteWriteSamples(conf *config, job string, now time.Time, ctx context.Context) (samples []remoteWriteSample) {
+	names := make([]string, 0, len(conf.targets))
+	for name := range conf.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := conf.targets[name]
+		center, _ := graticuleFromPoint(t.lat, t.lon)
+		results := computeHashes(neighbourhood(center, t.radius), t.globalhash, now.In(t.tz), ctx)
+
+		for _, result := range results {
+			if result.err != nil && len(result.hashes) == 0 {
+				log.Printf("Requesting %s for %s failed: %v", result.name(), name, result.err)
+				continue
+			}
+
+			for _, hash := range result.hashes {
+				year, month, day := hash.Date.Date()
+				validFrom := time.Date(year, month, day, 0, 0, 0, 0, t.tz)
+				if validFrom.After(now) {
+					continue
+				}
+
+				values := []struct {
+					name  string
+					value float64
+				}{
+					{"geohashing_lat", hash.Lat},
+					{"geohashing_lon", hash.Lon},
+					{"geohashing_distance_meters", distance(t.lat, t.lon, hash.Lat, hash.Lon)},
+				}
+				for _, value := range values {
+					labels := map[string]string{
+						"__name__":   value.name,
+						"job":        job,
+						"target":     name,
+						"location":   result.name(),
+						"lat_offset": "",
+						"lon_offset": "",
+						"graticule":  "",
+						"w30":        fmt.Sprintf("%t", hash.W30Rule),
+					}
+					if n := result.neighbour; n != nil {
+						labels["lat_offset"] = fmt.Sprintf("%d", n.latOffset)
+						labels["lon_offset"] = fmt.Sprintf("%d", n.lonOffset)
+						labels["graticule"] = n.graticule.String()
+					}
+					// Empty labels must not be sent.
+					for label, labelValue := range labels {
+						if labelValue == "" {
+							delete(labels, label)
+						}
+					}
+
+					samples = append(samples, remoteWriteSample{
+						labels:    labels,
+						value:     value.value,
+						timestamp: validFrom,
+					})
+				}
+			}
+		}
+	}
+	return
+}
+
+// errRemoteWriteRejected is returned if the remote write endpoint rejected the
+// samples as too old or out of order, e.g., if its TSDB has no out-of-order
+// time window. Sending the same samples again would not help.
+var errRemoteWriteRejected = errors.New("samples were rejected as too old or out of order")
+
+// remoteWriteRejections are substrings of the error messages of Prometheus and
+// Mimir, if samples are too old or out of order.
+var remoteWriteRejections = []string{"out of bounds", "too old", "out of order"}
+
+// remoteWrite sends the samples to the remote write endpoint.
+func remoteWrite(client *http.Client, url string, samples []remoteWriteSample, ctx context.Context) error {
+	body := snappy.Encode(nil, remoteWriteRequest(samples))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "geohashing_exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		msg = bytes.TrimSpace(msg)
+
+		if resp.StatusCode == http.StatusBadRequest {
+			for _, rejection := range remoteWriteRejections {
+				if strings.Contains(strings.ToLower(string(msg)), rejection) {
+					return fmt.Errorf("%w: %s", errRemoteWriteRejected, msg)
+				}
+			}
+		}
+		return fmt.Errorf("remote write endpoint responded with %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// remoteWriter sends each sample only once to the remote write endpoint, as the
+// same samples are created again each interval until the next day.
+type remoteWriter struct {
+	client *http.Client
+	url    string
+
+	// written is the timestamp of each series' last written sample by the
+	// series' labels.
+	written map[string]time.Time
+}
+
+// newRemoteWriter for the remote write endpoint's URL.
+func newRemoteWriter(client *http.Client, url string) *remoteWriter {
+	return &remoteWriter{
+		client:  client,
+		url:     url,
+		written: make(map[string]time.Time),
+	}
+}
+
+// seriesKey identifies the series of a sample by its sorted labels.
+func (sample remoteWriteSample) seriesKey() string {
+	labels := make([]string, 0, len(sample.labels))
+	for name, value := range sample.labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// write the samples, which were not already written, returning their number.
+// Rejected samples are not sent again, while other errors are retried by the
+// next write.
+func (w *remoteWriter) write(samples []remoteWriteSample, ctx context.Context) (n int, err error) {
+	var unwritten []remoteWriteSample
+	for _, sample := range samples {
+		if last, ok := w.written[sample.seriesKey()]; !ok || sample.timestamp.After(last) {
+			unwritten = append(unwritten, sample)
+		}
+	}
+
+	n = len(unwritten)
+	if n == 0 {
+		return
+	}
+
+	err = remoteWrite(w.client, w.url, unwritten, ctx)
+	if err == nil || errors.Is(err, errRemoteWriteRejected) {
+		for _, sample := range unwritten {
+			w.written[sample.seriesKey()] = sample.timestamp
+		}
+	}
+	return
+}
+
+// remoteWriteCommand implements the remote-write subcommand, sending the
+// geohashes of all configured locations each interval or only once. Within a
+// run, each day's samples are only sent once.
+func remoteWriteCommand(args []string) error {
+	flags := flag.NewFlagSet("remote-write", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML configuration file with named locations")
+	url := flags.String("url", "", "Remote write endpoint URL, optionally with basic auth credentials")
+	job := flags.String("job", "geohashing", "Job label of the written series")
+	interval := flags.Duration("interval", 15*time.Minute, "Interval between writes of new samples, which are up to a day old and require an out-of-order time window")
+	once := flags.Bool("once", false, "Write only once, e.g., when scheduled by cron")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *configFile == "" {
+		return fmt.Errorf("-config is required")
+	} else if *url == "" {
+		return fmt.Errorf("-url is required")
+	}
+	if *interval < time.Second {
+		return fmt.Errorf("interval must be at least one second")
+	}
+
+	// The configuration must be read before dropping privileges.
+	conf, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if len(conf.targets) == 0 {
+		return fmt.Errorf("no locations are configured in %s", *configFile)
+	}
+
+	toLeastPrivilege()
+
+	writer := newRemoteWriter(&http.Client{Timeout: 30 * time.Second}, *url)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		var n int
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		samples := remoteWriteSamples(conf, *job, time.Now(), ctx)
+		if len(samples) > 0 {
+			n, err = writer.write(samples, ctx)
+		} else {
+			err = fmt.Errorf("no geohashes are available")
+		}
+		cancel()
+
+		// Rejected samples are too old for the TSDB and will not be sent again.
+		if errors.Is(err, errRemoteWriteRejected) {
+			log.Printf("Remote write endpoint rejected %d samples, which might require an out-of-order time window: %v", n, err)
+			err = nil
+		} else if err == nil && n > 0 {
+			log.Printf("Wrote %d samples to %s", n, *url)
+		}
+
+		if *once {
+			return err
+		} else if err != nil {
+			log.Printf("Remote write failed: %v", err)
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields splits a protobuf message into its fields' numbers and values.
+// Varint and fixed64 values are returned as their eight little-endian bytes.
+func protoFields(msg []byte) (fields []struct {
+	num   protowire.Number
+	value []byte
+}, err error) {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(msg)
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(msg)
+			value = protowire.AppendFixed64(nil, v)
+		case protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(msg)
+			value = protowire.AppendFixed64(nil, v)
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		fields = append(fields, struct {
+			num   protowire.Number
+			value []byte
+		}{num, value})
+	}
+	return
+}
+
+// parseWriteRequest decodes a prometheus.WriteRequest as created by
+// remoteWriteRequest, allowing only one sample per series.
+func parseWriteRequest(msg []byte) (samples []remoteWriteSample, err error) {
+	req, err := protoFields(msg)
+	if err != nil {
+		return
+	}
+
+	for _, series := range req {
+		seriesFields, err := protoFields(series.value)
+		if err != nil {
+			return nil, err
+		}
+
+		sample := remoteWriteSample{labels: make(map[string]string)}
+		lastName := ""
+		for _, field := range seriesFields {
+			parts, err := protoFields(field.value)
+			if err != nil || len(parts) != 2 {
+				return nil, fmt.Errorf("invalid field %d: %v", field.num, err)
+			}
+
+			switch field.num {
+			case 1:
+				name, value := string(parts[0].value), string(parts[1].value)
+				if name <= lastName {
+					return nil, fmt.Errorf("label %q is not sorted", name)
+				}
+				lastName = name
+				sample.labels[name] = value
+			case 2:
+				v, _ := protowire.ConsumeFixed64(parts[0].value)
+				ts, _ := protowire.ConsumeFixed64(parts[1].value)
+				sample.value = math.Float64frombits(v)
+				sample.timestamp = time.UnixMilli(int64(ts))
+			}
+		}
+		samples = append(samples, sample)
+	}
+	return
+}
+
+func TestRemoteWrite(t *testing.T) {
+	setupTestProvider(t)
+
+	requests := make(chan []remoteWriteSample, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			http.Error(w, "unexpected headers", http.StatusBadRequest)
+			return
+		}
+
+		compressed, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		samples, err := parseWriteRequest(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if len(samples) == 0 {
+			http.Error(w, "empty write request", http.StatusBadRequest)
+			return
+		}
+
+		requests <- samples
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	conf := testConfig()
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+
+	samples := remoteWriteSamples(conf, "geohashing", now, context.Background())
+	if err := remoteWrite(http.DefaultClient, server.URL, samples, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	received := <-requests
+
+	// Only today's geohashes of all nine graticules and the globalhash are
+	// written, each with three series.
+	if len(received) != 30 {
+		t.Fatalf("expected 30 samples instead of %d", len(received))
+	}
+
+	validFrom := time.Date(2022, time.July, 16, 0, 0, 0, 0, tz)
+	found := 0
+	for _, sample := range received {
+		if !sample.timestamp.Equal(validFrom) {
+			t.Fatalf("expected timestamp %v instead of %v", validFrom, sample.timestamp)
+		}
+		if sample.labels["job"] != "geohashing" || sample.labels["target"] != "home" {
+			t.Fatalf("unexpected labels %v", sample.labels)
+		}
+		if _, ok := sample.labels["date"]; ok {
+			t.Fatalf("unexpected date label in %v", sample.labels)
+		}
+
+		switch {
+		case sample.labels["__name__"] == "geohashing_lat" && sample.labels["location"] == "center":
+			if sample.labels["graticule"] != "52,13" || math.Abs(sample.value-52.991783) > 0.000001 {
+				t.Fatalf("unexpected center sample %v", sample)
+			}
+			found++
+		case sample.labels["__name__"] == "geohashing_lon" && sample.labels["location"] == "global":
+			if _, ok := sample.labels["graticule"]; ok || math.Abs(sample.value+105.946114) > 0.000001 {
+				t.Fatalf("unexpected global sample %v", sample)
+			}
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("expected both the center and the global sample instead of %d", found)
+	}
+
+	// A rejected write is an error.
+	if err := remoteWrite(http.DefaultClient, server.URL, nil, context.Background()); err == nil {
+		t.Fatal("expected an error for a rejected write")
+	}
+}
+
+func TestRemoteWriter(t *testing.T) {
+	setupTestProvider(t)
+
+	// The endpoint responds with the given status and message, counting the
+	// received requests.
+	var requests atomic.Int64
+	var responseMu sync.Mutex
+	status, msg := http.StatusNoContent, ""
+	respond := func(newStatus int, newMsg string) {
+		responseMu.Lock()
+		defer responseMu.Unlock()
+		status, msg = newStatus, newMsg
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		responseMu.Lock()
+		defer responseMu.Unlock()
+		if status == http.StatusNoContent {
+			w.WriteHeader(status)
+			return
+		}
+		http.Error(w, msg, status)
+	}))
+	defer server.Close()
+
+	conf := testConfig()
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2022, time.July, 16, 12, 0, 0, 0, tz)
+	samples := remoteWriteSamples(conf, "geohashing", now, context.Background())
+
+	t.Run("once", func(t *testing.T) {
+		requests.Store(0)
+		respond(http.StatusNoContent, "")
+		writer := newRemoteWriter(http.DefaultClient, server.URL)
+
+		if n, err := writer.write(samples, context.Background()); err != nil || n != 30 {
+			t.Fatalf("unexpected first write of %d samples: %v", n, err)
+		}
+		// The same day's samples are not written again.
+		if n, err := writer.write(samples, context.Background()); err != nil || n != 0 {
+			t.Fatalf("unexpected second write of %d samples: %v", n, err)
+		}
+		// The next day's samples are written.
+		next := remoteWriteSamples(conf, "geohashing", now.AddDate(0, 0, 1), context.Background())
+		if n, err := writer.write(next, context.Background()); err != nil || n != 30 {
+			t.Fatalf("unexpected next day's write of %d samples: %v", n, err)
+		}
+		if requests.Load() != 2 {
+			t.Fatalf("expected two requests instead of %d", requests.Load())
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		requests.Store(0)
+		respond(http.StatusBadRequest, "out of bounds")
+		writer := newRemoteWriter(http.DefaultClient, server.URL)
+
+		if n, err := writer.write(samples, context.Background()); !errors.Is(err, errRemoteWriteRejected) || n != 30 {
+			t.Fatalf("unexpected write of %d samples: %v", n, err)
+		}
+		// Rejected samples are not sent again.
+		if n, err := writer.write(samples, context.Background()); err != nil || n != 0 {
+			t.Fatalf("unexpected second write of %d samples: %v", n, err)
+		}
+		if requests.Load() != 1 {
+			t.Fatalf("expected one request instead of %d", requests.Load())
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		requests.Store(0)
+		respond(http.StatusServiceUnavailable, "unavailable")
+		writer := newRemoteWriter(http.DefaultClient, server.URL)
+
+		if _, err := writer.write(samples, context.Background()); err == nil || errors.Is(err, errRemoteWriteRejected) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		// Failed samples are retried.
+		respond(http.StatusNoContent, "")
+		if n, err := writer.write(samples, context.Background()); err != nil || n != 30 {
+			t.Fatalf("unexpected retry of %d samples: %v", n, err)
+		}
+		if requests.Load() != 2 {
+			t.Fatalf("expected two requests instead of %d", requests.Load())
+		}
+	})
+}
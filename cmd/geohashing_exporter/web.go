@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the optional web UI, showing the neighbourhood on a map
+// for those who do not speak PromQL. All assets are embedded.
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// webPrefix is the path under which the web UI is served.
+const webPrefix = "/ui/"
+
+// webHandler serves the embedded web UI, which uses the JSON REST API.
+func webHandler() http.Handler {
+	assets, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		// The embedded directory exists at compile time.
+		panic(err)
+	}
+
+	return http.StripPrefix(webPrefix, http.FileServer(http.FS(assets)))
+}
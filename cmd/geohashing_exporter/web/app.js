@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains the web UI, rendering the /api/v1/neighbourhood endpoint's
+// 3x3 neighbourhood as a map and a table.
+
+"use strict";
+
+const dayColors = ["#d7191c", "#fdae61", "#2c7bb6", "#abd9e9", "#1a9641"];
+const mapSize = 600;
+const svgNs = "http://www.w3.org/2000/svg";
+
+// graticuleBounds of a graticule part, e.g., "50" is [50, 51] and "-0" is
+// [-1, 0].
+function graticuleBounds(part) {
+  const n = Number(part);
+  return part.startsWith("-") ? [n - 1, n] : [n, n + 1];
+}
+
+function svgElement(name, attrs, text) {
+  const elem = document.createElementNS(svgNs, name);
+  for (const [key, value] of Object.entries(attrs)) {
+    elem.setAttribute(key, value);
+  }
+  if (text !== undefined) {
+    elem.textContent = text;
+  }
+  return elem;
+}
+
+function setStatus(text, isError) {
+  const status = document.getElementById("status");
+  status.textContent = text;
+  status.className = isError ? "error" : "";
+}
+
+// renderMap draws each graticule with its hashes and the home position,
+// projected equirectangular around the center.
+function renderMap(locations, home) {
+  const map = document.getElementById("map");
+  map.replaceChildren();
+
+  const graticules = locations.filter((loc) => loc.graticule);
+  if (graticules.length === 0) {
+    return;
+  }
+
+  const bounds = graticules.map((loc) => {
+    const [latPart, lonPart] = loc.graticule.split(",");
+    const [minLat, maxLat] = graticuleBounds(latPart);
+    let [minLon, maxLon] = graticuleBounds(lonPart);
+    // Keep the map continuous around the antimeridian.
+    const expectedLon = home.lon + loc.lon_offset;
+    if (minLon - expectedLon > 180) {
+      minLon -= 360;
+      maxLon -= 360;
+    } else if (expectedLon - minLon > 180) {
+      minLon += 360;
+      maxLon += 360;
+    }
+    return { loc, minLat, maxLat, minLon, maxLon };
+  });
+
+  const minLat = Math.min(...bounds.map((b) => b.minLat));
+  const maxLat = Math.max(...bounds.map((b) => b.maxLat));
+  const minLon = Math.min(...bounds.map((b) => b.minLon));
+  const maxLon = Math.max(...bounds.map((b) => b.maxLon));
+
+  const lonScale = Math.cos(((minLat + maxLat) / 2) * Math.PI / 180);
+  const scale = mapSize / Math.max(maxLat - minLat, (maxLon - minLon) * lonScale);
+  const x = (lon) => (lon - minLon) * lonScale * scale;
+  const y = (lat) => (maxLat - lat) * scale;
+  map.setAttribute("viewBox", `0 0 ${x(maxLon)} ${y(minLat)}`);
+
+  for (const b of bounds) {
+    const center = b.loc.location === "center" ? " center" : "";
+    map.appendChild(svgElement("rect", {
+      class: "graticule" + center,
+      x: x(b.minLon), y: y(b.maxLat),
+      width: x(b.maxLon) - x(b.minLon), height: y(b.minLat) - y(b.maxLat),
+    }));
+    map.appendChild(svgElement("text", {
+      class: "graticule-name", x: x(b.minLon) + 4, y: y(b.maxLat) + 14,
+    }, b.loc.graticule));
+
+    for (const hash of b.loc.hashes) {
+      let lon = hash.lon;
+      if (lon < b.minLon) {
+        lon += 360;
+      } else if (lon > b.maxLon) {
+        lon -= 360;
+      }
+      const circle = svgElement("circle", {
+        cx: x(lon), cy: y(hash.lat), r: 6,
+        fill: dayColors[hash.day_offset % dayColors.length],
+      });
+      circle.appendChild(svgElement("title", {}, `${hash.date}: ${hash.lat.toFixed(6)}, ${hash.lon.toFixed(6)}`));
+      map.appendChild(circle);
+    }
+  }
+
+  const homeMarker = svgElement("circle", { class: "home", cx: x(home.lon), cy: y(home.lat), r: 4 });
+  homeMarker.appendChild(svgElement("title", {}, "Home"));
+  map.appendChild(homeMarker);
+}
+
+function renderLegend(locations) {
+  const legend = document.getElementById("legend");
+  legend.replaceChildren();
+
+  const dates = new Map();
+  for (const loc of locations) {
+    for (const hash of loc.hashes) {
+      dates.set(hash.day_offset, hash.date);
+    }
+  }
+
+  for (const [dayOffset, date] of [...dates].sort((a, b) => a[0] - b[0])) {
+    const span = document.createElement("span");
+    span.style.color = dayColors[dayOffset % dayColors.length];
+    span.textContent = `● ${date}`;
+    legend.appendChild(span);
+  }
+}
+
+function renderTable(locations) {
+  const tbody = document.querySelector("#hashes tbody");
+  tbody.replaceChildren();
+
+  for (const loc of locations) {
+    const rows = loc.hashes.length > 0 ? loc.hashes : [null];
+    for (const hash of rows) {
+      const tr = document.createElement("tr");
+      const cells = hash === null
+        ? [loc.location, loc.graticule || "", loc.error ? loc.error.reason : "unavailable", "", "", "", ""]
+        : [
+          loc.location,
+          loc.graticule || "",
+          hash.date,
+          hash.lat.toFixed(6),
+          hash.lon.toFixed(6),
+          hash.distance_meters === undefined ? "" : `${(hash.distance_meters / 1000).toFixed(1)} km`,
+          `${hash.djia.toFixed(2)} (${hash.djia_date})`,
+        ];
+
+      for (const cell of cells) {
+        const td = document.createElement("td");
+        td.textContent = cell;
+        if (loc.stale) {
+          td.className = "stale";
+        }
+        tr.appendChild(td);
+      }
+      tbody.appendChild(tr);
+    }
+  }
+}
+
+async function update() {
+  const form = document.getElementById("params");
+  const params = new URLSearchParams(new FormData(form));
+  if (params.get("date") === "") {
+    params.delete("date");
+  }
+  history.replaceState(null, "", "?" + params.toString());
+
+  const home = { lat: Number(params.get("lat")), lon: Number(params.get("lon")) };
+  params.set("radius", "1");
+  params.set("home", `${home.lat},${home.lon}`);
+
+  setStatus("Loading…", false);
+  try {
+    const resp = await fetch("../api/v1/neighbourhood?" + params.toString());
+    const body = await resp.json();
+    if (!resp.ok) {
+      setStatus(body.error.message, true);
+      return;
+    }
+
+    renderMap(body.locations, home);
+    renderLegend(body.locations);
+    renderTable(body.locations);
+    setStatus("", false);
+  } catch (err) {
+    setStatus(`Cannot load geohashes: ${err}`, true);
+  }
+}
+
+function init() {
+  const form = document.getElementById("params");
+  const query = new URLSearchParams(location.search);
+
+  form.tz.value = query.get("tz") || Intl.DateTimeFormat().resolvedOptions().timeZone;
+  for (const name of ["lat", "lon", "date"]) {
+    if (query.has(name)) {
+      form[name].value = query.get(name);
+    }
+  }
+
+  form.addEventListener("submit", (event) => {
+    event.preventDefault();
+    update();
+  });
+
+  document.getElementById("locate").addEventListener("click", () => {
+    navigator.geolocation.getCurrentPosition(
+      (pos) => {
+        form.lat.value = pos.coords.latitude.toFixed(6);
+        form.lon.value = pos.coords.longitude.toFixed(6);
+        update();
+      },
+      (err) => setStatus(`Cannot determine position: ${err.message}`, true));
+  });
+
+  if (form.lat.value !== "" && form.lon.value !== "") {
+    update();
+  }
+}
+
+init();
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebHandler(t *testing.T) {
+	handler := webHandler()
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/ui/", http.StatusOK, "text/html"},
+		{"/ui/app.js", http.StatusOK, "javascript"},
+		{"/ui/style.css", http.StatusOK, "text/css"},
+		{"/ui/missing.js", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d instead of %d", test.status, rec.Code)
+			}
+			if contentType := rec.Header().Get("Content-Type"); !strings.Contains(contentType, test.contentType) {
+				t.Fatalf("expected content type %q instead of %q", test.contentType, contentType)
+			}
+		})
+	}
+}
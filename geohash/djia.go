@@ -9,17 +9,32 @@ package geohash
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 )
 
+// ErrDjiaUnavailable is returned if the DJIA cannot be fetched from any API,
+// e.g., because it was not yet published or all APIs are unreachable.
+var ErrDjiaUnavailable = errors.New("cannot fetch DJIA from any API")
+
 // djiaFetchApi the DJIA for the given date utilizing a given API endpoint.
 func djiaFetchApi(apiUrl string, date time.Time, ctx context.Context) (djia float64, err error) {
+	ctx, end := trace(ctx, "djiaFetchApi")
+	defer func() { end(err) }()
+
+	if hook := getHooks().DjiaFetch; hook != nil {
+		startTime := time.Now()
+		defer func() { hook(ctx, djiaApiSource(apiUrl), time.Since(startTime), err) }()
+	}
+
 	reqUrl := date.Format(apiUrl)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
 	if err != nil {
@@ -49,15 +64,28 @@ func djiaFetchApi(apiUrl string, date time.Time, ctx context.Context) (djia floa
 	return
 }
 
+// djiaApiSource extracts the host name of an API URL, used to identify it.
+func djiaApiSource(apiUrl string) string {
+	u, err := url.Parse(apiUrl)
+	if err != nil || u.Host == "" {
+		return apiUrl
+	}
+	return u.Host
+}
+
+// djiaApiUrls are the API endpoints to be queried by djiaFetch. Each URL is a
+// time.Time.Format layout for the requested date.
+var djiaApiUrls = []string{
+	// https://geohashing.site/geohashing/Dow_Jones_Industrial_Average#geo.crox.net_.28recommended.29
+	"http://geo.crox.net/djia/2006/01/02",
+
+	// https://geohashing.site/geohashing/Dow_Jones_Industrial_Average#carabiner.peeron.com
+	"http://carabiner.peeron.com/xkcd/map/data/2006/01/02",
+}
+
 // djiaFetch the DJIA for the given date utilizing different APIs.
 func djiaFetch(date time.Time, ctx context.Context) (djia float64, err error) {
-	apiUrls := []string{
-		// https://geohashing.site/geohashing/Dow_Jones_Industrial_Average#geo.crox.net_.28recommended.29
-		"http://geo.crox.net/djia/2006/01/02",
-
-		// https://geohashing.site/geohashing/Dow_Jones_Industrial_Average#carabiner.peeron.com
-		"http://carabiner.peeron.com/xkcd/map/data/2006/01/02",
-	}
+	apiUrls := djiaApiUrls
 
 	type djiaApiResult struct {
 		djia float64
@@ -92,7 +120,7 @@ func djiaFetch(date time.Time, ctx context.Context) (djia float64, err error) {
 		}
 	}
 
-	err = fmt.Errorf("Cannot fetch DJIA from any API: %w", err)
+	err = fmt.Errorf("%w: %v", ErrDjiaUnavailable, err)
 	return
 }
 
@@ -106,31 +134,100 @@ type dowJonesIndustrialAvgProvider interface {
 
 // DowJonesIndustrialAvgCache implements geohash.dowJonesIndustrialAvgManager
 // backed by a LRU cache.
+//
+// Concurrent requests for the same uncached date are merged into one fetch.
 type dowJonesIndustrialAvgCache struct {
 	cache *lru.Cache[string, float64]
+
+	// fetch is djiaFetch, but might be altered for testing.
+	fetch func(time.Time, context.Context) (float64, error)
+
+	inflight     map[string]*djiaInflightFetch
+	inflightLock sync.Mutex
+}
+
+// djiaInflightFetch is a currently running fetch, shared between all requests
+// for the same date. After done is closed, djia and err are set.
+type djiaInflightFetch struct {
+	done chan struct{}
+	djia float64
+	err  error
 }
 
 // newDjiaCache to query DJIA with a LRU cache.
 func newDjiaCache() (djiaCache *dowJonesIndustrialAvgCache) {
-	djiaCache = &dowJonesIndustrialAvgCache{}
+	djiaCache = &dowJonesIndustrialAvgCache{
+		fetch:    djiaFetch,
+		inflight: make(map[string]*djiaInflightFetch),
+	}
 	djiaCache.cache, _ = lru.New[string, float64](16)
 	return
 }
 
+// djiaFetchTimeout bounds a shared fetch, which is detached from the contexts
+// of the awaiting requests.
+const djiaFetchTimeout = 30 * time.Second
+
+// detachedContext keeps the values of its parent, e.g., for tracing, but is
+// neither canceled nor has a deadline.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+
 // Get the DJIA value for the given date.
+//
+// If another request for the same date is already being fetched, its result
+// will be awaited. The shared fetch runs detached from the requests' contexts,
+// bounded by djiaFetchTimeout, while each request only waits until its own
+// context is done.
 func (djiaCache *dowJonesIndustrialAvgCache) Get(date time.Time, ctx context.Context) (djia float64, err error) {
+	ctx, end := trace(ctx, "djiaCache.Get")
+	defer func() { end(err) }()
+
 	cacheKey := date.Format("2006-01-02")
 	cachedDjia, cacheHit := djiaCache.cache.Get(cacheKey)
+	if hook := getHooks().DjiaCache; hook != nil {
+		hook(ctx, cacheHit)
+	}
 	if cacheHit {
 		djia = cachedDjia
 		return
 	}
 
-	djia, err = djiaFetch(date, ctx)
-	if err != nil {
-		return
+	djiaCache.inflightLock.Lock()
+	inflight, isInflight := djiaCache.inflight[cacheKey]
+	if !isInflight {
+		inflight = &djiaInflightFetch{done: make(chan struct{})}
+		djiaCache.inflight[cacheKey] = inflight
+	}
+	djiaCache.inflightLock.Unlock()
+
+	if !isInflight {
+		go func() {
+			fetchCtx, cancel := context.WithTimeout(detachedContext{ctx}, djiaFetchTimeout)
+			defer cancel()
+
+			inflight.djia, inflight.err = djiaCache.fetch(date, fetchCtx)
+			if inflight.err == nil {
+				_ = djiaCache.cache.Add(cacheKey, inflight.djia)
+			}
+
+			djiaCache.inflightLock.Lock()
+			delete(djiaCache.inflight, cacheKey)
+			djiaCache.inflightLock.Unlock()
+			close(inflight.done)
+		}()
 	}
 
-	_ = djiaCache.cache.Add(cacheKey, djia)
+	select {
+	case <-inflight.done:
+		djia, err = inflight.djia, inflight.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 	return
 }
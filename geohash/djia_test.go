@@ -6,7 +6,11 @@ package geohash
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -43,6 +47,9 @@ func TestDjiaFetch(t *testing.T) {
 				t.Fatalf("unexpected result: %q", err)
 			}
 			if !test.success {
+				if !errors.Is(err, ErrDjiaUnavailable) {
+					t.Fatalf("expected ErrDjiaUnavailable instead of %v", err)
+				}
 				return
 			}
 
@@ -99,3 +106,82 @@ func TestDowJonesIndustrialAvgCache(t *testing.T) {
 		}
 	}
 }
+
+func TestDowJonesIndustrialAvgCacheConcurrent(t *testing.T) {
+	var fetches atomic.Int32
+
+	djiaCache := newDjiaCache()
+	djiaCache.fetch = func(date time.Time, ctx context.Context) (float64, error) {
+		fetches.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return 23.42, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	date, _ := time.Parse("2006-01-02", "2022-01-01")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			djia, err := djiaCache.Get(date, ctx)
+			if err != nil {
+				t.Error(err)
+			} else if djia != 23.42 {
+				t.Errorf("unexpected DJIA %f", djia)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected one fetch instead of %d", n)
+	}
+	if l := len(djiaCache.inflight); l != 0 {
+		t.Fatalf("%d fetches are still inflight", l)
+	}
+}
+
+func TestDowJonesIndustrialAvgCacheCanceled(t *testing.T) {
+	release := make(chan struct{})
+
+	djiaCache := newDjiaCache()
+	djiaCache.fetch = func(date time.Time, ctx context.Context) (float64, error) {
+		select {
+		case <-release:
+			return 23.42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	date, _ := time.Parse("2006-01-02", "2022-01-01")
+
+	// The first request gives up early, while the shared fetch continues.
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer shortCancel()
+	if _, err := djiaCache.Get(date, shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error instead of %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		djia, err := djiaCache.Get(date, ctx)
+		if err == nil && djia != 23.42 {
+			err = fmt.Errorf("unexpected DJIA %f", djia)
+		}
+		done <- err
+	}()
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
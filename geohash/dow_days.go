@@ -4,7 +4,8 @@
 
 // This file eases detecting if the New York Stock Exchange (NYSE) was open at
 // a given date or if an earlier day should be used - checks weekends and Dow
-// holidays. For external usage, there is only the CorrectDowDate function.
+// holidays. For external usage, there are the NextDowOpening and the
+// LastDowOpening functions.
 
 package geohash
 
@@ -14,11 +15,11 @@ import (
 	"time"
 )
 
-// nyseTz returns the time zone of the NYSE, America/New_York, ET (UTC-05:00)
+// NyseTz returns the time zone of the NYSE, America/New_York, ET (UTC-05:00)
 // with daylight saving time (UTC-04:00).
 //
 // Please note: This function panics if it is unable to load the time zone.
-func nyseTz() *time.Location {
+func NyseTz() *time.Location {
 	loc, err := time.LoadLocation("America/New_York")
 	if err != nil {
 		panic(err)
@@ -33,7 +34,7 @@ type dowDayValidator func(time.Time) (isClosed bool)
 // dowHourCheckMarketClosed verifies a given time against the NYSE opening time
 // in the New York time zone.
 func dowHourCheckMarketClosed(date time.Time) bool {
-	nyseDate := date.In(nyseTz())
+	nyseDate := date.In(NyseTz())
 	hour, min, _ := nyseDate.Clock()
 	return hour*100+min < 930
 }
@@ -80,7 +81,7 @@ func mkDowYearly(algorithm func(int) time.Time) dowDayValidator {
 // https://www.opm.gov/policy-data-oversight/pay-leave/federal-holidays/
 func mkDowYearlyFixedDate(month time.Month, day int) dowDayValidator {
 	return mkDowYearly(func(year int) time.Time {
-		day := time.Date(year, month, day, 0, 0, 0, 0, nyseTz())
+		day := time.Date(year, month, day, 0, 0, 0, 0, NyseTz())
 
 		switch day.Weekday() {
 		case time.Saturday:
@@ -98,7 +99,7 @@ func mkDowYearlyFixedDate(month time.Month, day int) dowDayValidator {
 // Day occurring each third Monday in January.
 func mkDowYearlyNthDay(month time.Month, nth int, weekday time.Weekday) dowDayValidator {
 	return mkDowYearly(func(year int) time.Time {
-		day := time.Date(year, month, 1, 0, 0, 0, 0, nyseTz())
+		day := time.Date(year, month, 1, 0, 0, 0, 0, NyseTz())
 		for day.Weekday() != weekday {
 			day = day.Add(24 * time.Hour)
 		}
@@ -132,7 +133,7 @@ var (
 
 // dowDayMemorialDay checks for the Memorial Day, last Monday in May.
 var dowDayMemorialDay = mkDowYearly(func(year int) time.Time {
-	day := time.Date(year, time.May, 31, 0, 0, 0, 0, nyseTz())
+	day := time.Date(year, time.May, 31, 0, 0, 0, 0, NyseTz())
 	for day.Weekday() != time.Monday {
 		day = day.Add(-24 * time.Hour)
 	}
@@ -156,9 +157,9 @@ var dowDayGoodFriday = mkDowYearly(func(year int) time.Time {
 
 	goodFriday := 20 + d + e
 	if goodFriday <= 31 {
-		return time.Date(year, time.March, goodFriday, 0, 0, 0, 0, nyseTz())
+		return time.Date(year, time.March, goodFriday, 0, 0, 0, 0, NyseTz())
 	} else {
-		return time.Date(year, time.April, goodFriday-31, 0, 0, 0, 0, nyseTz())
+		return time.Date(year, time.April, goodFriday-31, 0, 0, 0, 0, NyseTz())
 	}
 })
 
@@ -209,3 +210,30 @@ func correctDowDate(date time.Time) (realDate time.Time, err error) {
 	err = fmt.Errorf("cannot correct date: NYSE shouldn't be closed seven days in a row")
 	return
 }
+
+// dowOpening returns the NYSE opening, 09:30 in New York, of the given date's
+// day in New York.
+func dowOpening(date time.Time) time.Time {
+	year, month, day := date.In(NyseTz()).Date()
+	return time.Date(year, month, day, 9, 30, 0, 0, NyseTz())
+}
+
+// NextDowOpening returns the next NYSE opening, 09:30 in New York, after the
+// given date. Both weekends and Dow holidays are skipped.
+func NextDowOpening(date time.Time) time.Time {
+	opening := dowOpening(date)
+	for !opening.After(date) || isDowHoliday(opening) {
+		opening = dowOpening(opening.AddDate(0, 0, 1))
+	}
+	return opening
+}
+
+// LastDowOpening returns the latest NYSE opening, 09:30 in New York, at or
+// before the given date. Both weekends and Dow holidays are skipped.
+func LastDowOpening(date time.Time) time.Time {
+	opening := dowOpening(date)
+	for opening.After(date) || isDowHoliday(opening) {
+		opening = dowOpening(opening.AddDate(0, 0, -1))
+	}
+	return opening
+}
@@ -11,7 +11,7 @@ import (
 )
 
 func TestDowHourCheckMarketClosed(t *testing.T) {
-	locNy := nyseTz()
+	locNy := NyseTz()
 	locBerlin, _ := time.LoadLocation("Europe/Berlin")
 
 	tests := []struct {
@@ -88,8 +88,8 @@ func TestCorrectDowDate(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.date, func(t *testing.T) {
-			date, _ := time.ParseInLocation("2006-01-02 15:04", test.date+" 09:30", nyseTz())
-			corrected, _ := time.ParseInLocation("2006-01-02 15:04", test.corrected+" 09:30", nyseTz())
+			date, _ := time.ParseInLocation("2006-01-02 15:04", test.date+" 09:30", NyseTz())
+			corrected, _ := time.ParseInLocation("2006-01-02 15:04", test.corrected+" 09:30", NyseTz())
 
 			out, err := correctDowDate(date)
 			if err != nil {
@@ -102,3 +102,55 @@ func TestCorrectDowDate(t *testing.T) {
 		})
 	}
 }
+
+func TestDowOpening(t *testing.T) {
+	locBerlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		date string
+		loc  *time.Location
+		next string
+		last string
+	}{
+		// Regular working days
+		{"2022-07-14 09:00", NyseTz(), "2022-07-14 09:30", "2022-07-13 09:30"},
+		{"2022-07-14 09:30", NyseTz(), "2022-07-15 09:30", "2022-07-14 09:30"},
+		{"2022-07-14 12:00", NyseTz(), "2022-07-15 09:30", "2022-07-14 09:30"},
+
+		// Weekend
+		{"2022-07-15 12:00", NyseTz(), "2022-07-18 09:30", "2022-07-15 09:30"},
+		{"2022-07-16 12:00", NyseTz(), "2022-07-18 09:30", "2022-07-15 09:30"},
+		{"2022-07-18 09:00", NyseTz(), "2022-07-18 09:30", "2022-07-15 09:30"},
+
+		// Independence Day, 2022, on a Monday
+		{"2022-07-01 12:00", NyseTz(), "2022-07-05 09:30", "2022-07-01 09:30"},
+		{"2022-07-04 12:00", NyseTz(), "2022-07-05 09:30", "2022-07-01 09:30"},
+
+		// Other time zones; Berlin's 15:30 is NYSE's opening.
+		{"2022-07-14 15:00", locBerlin, "2022-07-14 09:30", "2022-07-13 09:30"},
+		{"2022-07-14 15:30", locBerlin, "2022-07-15 09:30", "2022-07-14 09:30"},
+		{"2022-07-16 01:00", locBerlin, "2022-07-18 09:30", "2022-07-15 09:30"},
+
+		// Daylight saving time transitions
+		{"2022-03-12 12:00", NyseTz(), "2022-03-14 09:30", "2022-03-11 09:30"},
+		{"2022-11-05 12:00", NyseTz(), "2022-11-07 09:30", "2022-11-04 09:30"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s;%v", test.loc, test.date), func(t *testing.T) {
+			date, err := time.ParseInLocation("2006-01-02 15:04", test.date, test.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, _ := time.ParseInLocation("2006-01-02 15:04", test.next, NyseTz())
+			last, _ := time.ParseInLocation("2006-01-02 15:04", test.last, NyseTz())
+
+			if out := NextDowOpening(date); !next.Equal(out) {
+				t.Fatalf("expected next opening %v instead of %v", next, out)
+			}
+			if out := LastDowOpening(date); !last.Equal(out) {
+				t.Fatalf("expected last opening %v instead of %v", last, out)
+			}
+		})
+	}
+}
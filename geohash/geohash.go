@@ -53,11 +53,38 @@ func GetGeoHashProvider() *GeoHashProvider {
 	return geoHashProviderInstance
 }
 
+// Djia returns the Dow Jones Industrial Average's opening value of the given
+// date, being either fetched or cached. There is no value for weekends or Dow
+// holidays, see LastDowOpening.
+func (provider *GeoHashProvider) Djia(date time.Time, ctx context.Context) (float64, error) {
+	return provider.djiaProvider.Get(date, ctx)
+}
+
+// DjiaSource fetches the Dow Jones Industrial Average's opening value of the
+// given date, e.g., from an alternative API or from a static data set.
+type DjiaSource func(date time.Time, ctx context.Context) (float64, error)
+
+// NewGeoHashProvider creates a GeoHashProvider with its own DJIA cache, backed
+// by the given DjiaSource instead of the default APIs.
+//
+// Usually, GetGeoHashProvider should be used instead.
+func NewGeoHashProvider(source DjiaSource) *GeoHashProvider {
+	djiaCache := newDjiaCache()
+	djiaCache.fetch = source
+
+	return &GeoHashProvider{
+		djiaProvider: djiaCache,
+	}
+}
+
 // normalizeDate based on the geographical location and the NYSE holidays.
 //
 // If the given date is a normal NYSE working day western of 30W,
 // ErrW30NotYetAvailable will be returned.
-func (provider *GeoHashProvider) normalizeDate(latArea, lonArea int, date time.Time) (queryDate time.Time, err error) {
+func (provider *GeoHashProvider) normalizeDate(latArea, lonArea int, date time.Time, ctx context.Context) (queryDate time.Time, err error) {
+	_, end := trace(ctx, "normalizeDate")
+	defer func() { end(err) }()
+
 	queryDate = date
 
 	if lonArea > -30 {
@@ -71,10 +98,29 @@ func (provider *GeoHashProvider) normalizeDate(latArea, lonArea int, date time.T
 	return
 }
 
-// Geo hash for a given location, latitude and longitude reduced to an integer,
-// and a date.
-func (provider *GeoHashProvider) Geo(latArea, lonArea int, date time.Time, ctx context.Context) (lat, lon float64, err error) {
-	queryDate, err := provider.normalizeDate(latArea, lonArea, date)
+// Hash is a calculated geohash together with the information it is based on.
+type Hash struct {
+	// Lat and Lon of the geohash.
+	Lat, Lon float64
+
+	// Date for which this geohash is valid.
+	Date time.Time
+
+	// Djia is the Dow Jones Industrial Average's opening value of DjiaDate, used
+	// to calculate this geohash.
+	Djia     float64
+	DjiaDate time.Time
+
+	// W30Rule is true if the 30W Time Zone Rule was applied, i.e., the location
+	// is east of 30 deg west and the previous day's DJIA was used.
+	//
+	// https://geohashing.site/geohashing/30W_Time_Zone_Rule
+	W30Rule bool
+}
+
+// geo calculates the Hash for a given location and date, see Geo.
+func (provider *GeoHashProvider) geo(latArea, lonArea int, date time.Time, ctx context.Context) (hash Hash, err error) {
+	queryDate, err := provider.normalizeDate(latArea, lonArea, date, ctx)
 	if err != nil {
 		return
 	}
@@ -84,6 +130,11 @@ func (provider *GeoHashProvider) Geo(latArea, lonArea int, date time.Time, ctx c
 		return
 	}
 
+	hash.Date = date
+	hash.Djia = djia
+	hash.DjiaDate = queryDate
+	hash.W30Rule = lonArea > -30
+
 	h := md5.Sum([]byte(fmt.Sprintf("%s-%.2f", date.Format("2006-01-02"), djia)))
 
 	fields := []struct {
@@ -91,8 +142,8 @@ func (provider *GeoHashProvider) Geo(latArea, lonArea int, date time.Time, ctx c
 		hash []byte
 		out  *float64
 	}{
-		{float64(latArea), h[0 : md5.Size/2], &lat},
-		{float64(lonArea), h[md5.Size/2 : md5.Size], &lon},
+		{float64(latArea), h[0 : md5.Size/2], &hash.Lat},
+		{float64(lonArea), h[md5.Size/2 : md5.Size], &hash.Lon},
 	}
 
 	for _, field := range fields {
@@ -104,57 +155,73 @@ func (provider *GeoHashProvider) Geo(latArea, lonArea int, date time.Time, ctx c
 	return
 }
 
+// Geo hash for a given location, latitude and longitude reduced to an integer,
+// and a date.
+func (provider *GeoHashProvider) Geo(latArea, lonArea int, date time.Time, ctx context.Context) (lat, lon float64, err error) {
+	hash, err := provider.geo(latArea, lonArea, date, ctx)
+	if err != nil {
+		return
+	}
+
+	lat, lon = hash.Lat, hash.Lon
+	return
+}
+
 // globalNormalizeDate for Globalhash calculation.
 func (provider *GeoHashProvider) globalNormalizeDate(date time.Time) time.Time {
 	year, month, day := date.Date()
 	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
 }
 
+// globalScale maps a geohash within the 0,0 graticule onto the whole globe.
+func globalScale(hash *Hash) {
+	hash.Lat = hash.Lat*180.0 - 90.0
+	hash.Lon = hash.Lon*360.0 - 180.0
+}
+
 // Global hash for a given date.
 //
 // Location information will be stripped to normalize the time.
 func (provider *GeoHashProvider) Global(date time.Time, ctx context.Context) (lat, lon float64, err error) {
 	normalizedDate := provider.globalNormalizeDate(date)
-	lat, lon, err = provider.Geo(0, 0, normalizedDate, ctx)
+	hash, err := provider.geo(0, 0, normalizedDate, ctx)
 	if err != nil {
 		return
 	}
 
-	lat = lat*180.0 - 90.0
-	lon = lon*360.0 - 180.0
-
+	globalScale(&hash)
+	lat, lon = hash.Lat, hash.Lon
 	return
 }
 
-// GeoNext calculates all possible future Geohashes after the given date.
+// GeoNextHashes calculates all possible future Geohashes after the given date.
 //
-// It returns an array of a two dimensional float64 array, representing lat and
-// lon. The index of the outer array is offset of days to the requested date
+// The index of the returned array is offset of days to the requested date
 // parameter, e.g., 0 is the requested date, 1 is the following one, and so on.
 //
 // On weekends or NYSE holidays, the last known Dow Jones Industrial Average
 // indicator will be used. For example, on Saturdays western of 30W, both the
 // date for tomorrow's Sunday as well as the DJIA value is known. Thus, the
 // Geohash's location for the following day can already be calculated.
-func (provider *GeoHashProvider) GeoNext(latArea, lonArea int, date time.Time, ctx context.Context) (locs [][]float64, err error) {
+func (provider *GeoHashProvider) GeoNextHashes(latArea, lonArea int, date time.Time, ctx context.Context) (hashes []Hash, err error) {
 	for {
-		lat, lon, geoErr := provider.Geo(latArea, lonArea, date, ctx)
+		hash, geoErr := provider.geo(latArea, lonArea, date, ctx)
 		if geoErr != nil {
 			return nil, geoErr
 		}
 
-		locs = append(locs, []float64{lat, lon})
+		hashes = append(hashes, hash)
 
-		baseDate, dateErr := provider.normalizeDate(latArea, lonArea, date)
+		baseDate, dateErr := provider.normalizeDate(latArea, lonArea, date, ctx)
 		if dateErr != nil {
 			return nil, dateErr
 		}
 
 		date = date.Add(24 * time.Hour)
 
-		compDate, dateErr := provider.normalizeDate(latArea, lonArea, date)
+		compDate, dateErr := provider.normalizeDate(latArea, lonArea, date, ctx)
 		if errors.Is(dateErr, ErrW30NotYetAvailable) {
-			// There is at least one coordinate pair in locs and the next possible
+			// There is at least one coordinate pair in hashes and the next possible
 			// day will be a new working day west of 30W, we can stop here.
 			break
 		} else if dateErr != nil {
@@ -167,22 +234,57 @@ func (provider *GeoHashProvider) GeoNext(latArea, lonArea int, date time.Time, c
 	return
 }
 
-// GlobalNext calculates all possible future Globalhashes after the given date.
+// hashesToLocs converts Hashes into an array of lat, lon arrays.
+func hashesToLocs(hashes []Hash) (locs [][]float64) {
+	for _, hash := range hashes {
+		locs = append(locs, []float64{hash.Lat, hash.Lon})
+	}
+	return
+}
+
+// GeoNext calculates all possible future Geohashes after the given date.
 //
-// For more information look at the documentation for GeoHashProvider.GeoNext.
-func (provider *GeoHashProvider) GlobalNext(date time.Time, ctx context.Context) (locs [][]float64, err error) {
+// It returns an array of a two dimensional float64 array, representing lat and
+// lon. For more information look at the documentation for
+// GeoHashProvider.GeoNextHashes.
+func (provider *GeoHashProvider) GeoNext(latArea, lonArea int, date time.Time, ctx context.Context) (locs [][]float64, err error) {
+	hashes, err := provider.GeoNextHashes(latArea, lonArea, date, ctx)
+	if err != nil {
+		return
+	}
+
+	locs = hashesToLocs(hashes)
+	return
+}
+
+// GlobalNextHashes calculates all possible future Globalhashes after the given
+// date.
+//
+// For more information look at the documentation for
+// GeoHashProvider.GeoNextHashes.
+func (provider *GeoHashProvider) GlobalNextHashes(date time.Time, ctx context.Context) (hashes []Hash, err error) {
 	normalizedDate := provider.globalNormalizeDate(date)
-	locs, err = provider.GeoNext(0, 0, normalizedDate, ctx)
+	hashes, err = provider.GeoNextHashes(0, 0, normalizedDate, ctx)
 	if err != nil {
 		return
 	}
 
-	for date, latLon := range locs {
-		locs[date] = []float64{
-			latLon[0]*180.0 - 90.0,
-			latLon[1]*360.0 - 180.0,
-		}
+	for i := range hashes {
+		globalScale(&hashes[i])
+	}
+
+	return
+}
+
+// GlobalNext calculates all possible future Globalhashes after the given date.
+//
+// For more information look at the documentation for GeoHashProvider.GeoNext.
+func (provider *GeoHashProvider) GlobalNext(date time.Time, ctx context.Context) (locs [][]float64, err error) {
+	hashes, err := provider.GlobalNextHashes(date, ctx)
+	if err != nil {
+		return
 	}
 
+	locs = hashesToLocs(hashes)
 	return
 }
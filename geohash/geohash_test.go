@@ -53,7 +53,7 @@ func (_ *testdjiaProvider) Get(date time.Time, _ context.Context) (float64, erro
 }
 
 func TestGeoHashProviderGeo(t *testing.T) {
-	locNy := nyseTz()
+	locNy := NyseTz()
 	locBerlin, _ := time.LoadLocation("Europe/Berlin")
 
 	tests := []struct {
@@ -117,7 +117,7 @@ func TestGeoHashProviderGeo(t *testing.T) {
 }
 
 func TestGeoHashProviderGlobal(t *testing.T) {
-	locNy := nyseTz()
+	locNy := NyseTz()
 	locBerlin, _ := time.LoadLocation("Europe/Berlin")
 
 	tests := []struct {
@@ -169,7 +169,7 @@ func TestGeoHashProviderGlobal(t *testing.T) {
 }
 
 func TestGeoHashProviderGeoNext(t *testing.T) {
-	locNy := nyseTz()
+	locNy := NyseTz()
 	locBerlin, _ := time.LoadLocation("Europe/Berlin")
 
 	tests := []struct {
@@ -233,7 +233,7 @@ func TestGeoHashProviderGeoNext(t *testing.T) {
 }
 
 func TestGeoHashProviderGlobalNext(t *testing.T) {
-	locNy := nyseTz()
+	locNy := NyseTz()
 	locBerlin, _ := time.LoadLocation("Europe/Berlin")
 
 	tests := []struct {
@@ -281,3 +281,79 @@ func TestGeoHashProviderGlobalNext(t *testing.T) {
 		})
 	}
 }
+
+func TestGeoHashProviderGeoNextHashes(t *testing.T) {
+	locNy := NyseTz()
+	locBerlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		date     string
+		loc      *time.Location
+		latArea  int
+		lonArea  int
+		dates    []string
+		djiaDate string
+		djia     float64
+		w30Rule  bool
+	}{
+		{"2022-07-15 00:00", locBerlin, 52, 13, []string{"2022-07-15"}, "2022-07-14", 30451.80, true},
+		{"2022-07-16 09:00", locBerlin, 52, 13, []string{"2022-07-16", "2022-07-17", "2022-07-18"}, "2022-07-15", 30775.37, true},
+		{"2022-07-15 09:30", locNy, 40, -74, []string{"2022-07-15", "2022-07-16", "2022-07-17"}, "2022-07-15", 30775.37, false},
+		{"2022-07-16 09:00", locNy, 40, -74, []string{"2022-07-16", "2022-07-17"}, "2022-07-15", 30775.37, false},
+	}
+
+	provider := GeoHashProvider{djiaProvider: &testdjiaProvider{}}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s;%v;%d,%d", test.loc, test.date, test.latArea, test.lonArea), func(t *testing.T) {
+			date, err := time.ParseInLocation("2006-01-02 15:04", test.date, test.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			hashes, err := provider.GeoNextHashes(test.latArea, test.lonArea, date, ctx)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(hashes) != len(test.dates) {
+				t.Fatalf("expected %d hashes instead of %d", len(test.dates), len(hashes))
+			}
+
+			for i, hash := range hashes {
+				if d := hash.Date.Format("2006-01-02"); d != test.dates[i] {
+					t.Fatalf("offset %d: expected date %s instead of %s", i, test.dates[i], d)
+				}
+				if d := hash.DjiaDate.Format("2006-01-02"); d != test.djiaDate {
+					t.Fatalf("offset %d: expected DJIA date %s instead of %s", i, test.djiaDate, d)
+				}
+				if hash.Djia != test.djia {
+					t.Fatalf("offset %d: expected DJIA %f instead of %f", i, test.djia, hash.Djia)
+				}
+				if hash.W30Rule != test.w30Rule {
+					t.Fatalf("offset %d: expected W30Rule %t", i, test.w30Rule)
+				}
+			}
+		})
+	}
+}
+
+func TestNewGeoHashProvider(t *testing.T) {
+	provider := NewGeoHashProvider(func(date time.Time, ctx context.Context) (float64, error) {
+		return (&testdjiaProvider{}).Get(date, ctx)
+	})
+
+	// Original comic, https://xkcd.com/426/
+	date, _ := time.ParseInLocation("2006-01-02 15:04", "2005-05-26 09:30", NyseTz())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lat, lon, err := provider.Geo(37, -122, date, ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if math.Abs(lat-37.857713) > 0.00001 || math.Abs(lon - -122.544544) > 0.00001 {
+		t.Fatalf("unexpected %f, %f", lat, lon)
+	}
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This file contains hooks to observe the package's internals.
+
+package geohash
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Hooks allow observing the internals of this package, e.g., to export metrics.
+// Each hook is optional and might be nil.
+//
+// Hooks are called synchronously and concurrently. Thus, they must be fast and
+// safe for concurrent use.
+type Hooks struct {
+	// DjiaFetch is called after fetching a DJIA value from a single API. The
+	// source is the API's host name, e.g., "geo.crox.net".
+	DjiaFetch func(ctx context.Context, source string, duration time.Duration, err error)
+
+	// DjiaCache is called for each DJIA lookup, reporting a cache hit or miss.
+	DjiaCache func(ctx context.Context, hit bool)
+
+	// Trace is called at the start of an operation, i.e., "normalizeDate",
+	// "djiaCache.Get", and "djiaFetchApi", e.g., to create tracing spans. It
+	// returns the operation's context, passed on to nested operations and the
+	// other hooks, and a function to be called with the operation's outcome.
+	Trace func(ctx context.Context, operation string) (context.Context, func(err error))
+}
+
+// hooks are the currently registered Hooks, set by SetHooks.
+var (
+	hooks     Hooks
+	hooksLock sync.RWMutex
+)
+
+// SetHooks to observe this package. This replaces all previously set hooks.
+func SetHooks(h Hooks) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	hooks = h
+}
+
+// getHooks returns the currently set Hooks.
+func getHooks() Hooks {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+
+	return hooks
+}
+
+// trace an operation by the Trace hook, if set. The returned end function must
+// be called after the operation with its error.
+func trace(ctx context.Context, operation string) (context.Context, func(err error)) {
+	if hook := getHooks().Trace; hook != nil {
+		return hook(ctx, operation)
+	}
+	return ctx, func(error) {}
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2023 Alvar Penning
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package geohash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	var (
+		fetchSources []string
+		fetchErrs    []error
+		cacheHits    []bool
+		operations   []string
+		lock         sync.Mutex
+	)
+
+	// traceKey marks contexts created by the Trace hook.
+	type traceKey struct{}
+
+	SetHooks(Hooks{
+		DjiaFetch: func(ctx context.Context, source string, _ time.Duration, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if ctx.Value(traceKey{}) != "djiaFetchApi" {
+				t.Errorf("DjiaFetch was not called within its trace")
+			}
+			fetchSources = append(fetchSources, source)
+			fetchErrs = append(fetchErrs, err)
+		},
+		DjiaCache: func(ctx context.Context, hit bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			if ctx.Value(traceKey{}) != "djiaCache.Get" {
+				t.Errorf("DjiaCache was not called within its trace")
+			}
+			cacheHits = append(cacheHits, hit)
+		},
+		Trace: func(ctx context.Context, operation string) (context.Context, func(error)) {
+			lock.Lock()
+			defer lock.Unlock()
+			operations = append(operations, operation)
+			return context.WithValue(ctx, traceKey{}, operation), func(error) {}
+		},
+	})
+	defer SetHooks(Hooks{})
+
+	// Port 0 is not reachable and fails fast.
+	oldDjiaApiUrls := djiaApiUrls
+	djiaApiUrls = []string{"http://localhost:0/2006/01/02"}
+	defer func() { djiaApiUrls = oldDjiaApiUrls }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	date, _ := time.Parse("2006-01-02", "2022-01-01")
+
+	djiaCache := newDjiaCache()
+	if _, err := djiaCache.Get(date, ctx); err == nil {
+		t.Fatal("unreachable API did not fail")
+	}
+
+	djiaCache.fetch = func(time.Time, context.Context) (float64, error) { return 23.42, nil }
+	for i := 0; i < 2; i++ {
+		if _, err := djiaCache.Get(date, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(fetchSources) != 1 || fetchSources[0] != "localhost:0" || fetchErrs[0] == nil {
+		t.Fatalf("unexpected fetches %v, %v", fetchSources, fetchErrs)
+	}
+	if len(cacheHits) != 3 || cacheHits[0] || cacheHits[1] || !cacheHits[2] {
+		t.Fatalf("unexpected cache hits %v", cacheHits)
+	}
+
+	expectedOperations := []string{"djiaCache.Get", "djiaFetchApi", "djiaCache.Get", "djiaCache.Get"}
+	if fmt.Sprint(operations) != fmt.Sprint(expectedOperations) {
+		t.Fatalf("expected operations %v instead of %v", expectedOperations, operations)
+	}
+
+	provider := &GeoHashProvider{djiaProvider: djiaCache}
+	operations = nil
+	if _, _, err := provider.Geo(50, 8, date, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expectedOperations = []string{"normalizeDate", "djiaCache.Get"}; fmt.Sprint(operations) != fmt.Sprint(expectedOperations) {
+		t.Fatalf("expected operations %v instead of %v", expectedOperations, operations)
+	}
+}